@@ -0,0 +1,69 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+)
+
+type OptionsStruct struct {
+	filename string
+	line     int // 1-based, 0 if not given
+	column   int // 1-based, 0 if not given
+	readonly bool
+	encoding string
+	config   string
+}
+
+var positionArg = regexp.MustCompile(`^\+(\d+)(?::(\d+))?$`)
+
+const usageText = `use: edit [flags] [+LINE[:COL]] <filename>
+
+Opens <filename> for editing. A missing file is created on first save.
+
+  +LINE[:COL]  place the cursor on line LINE (and column COL), 1-based
+
+flags:
+`
+
+func parseArgs(args []string, output io.Writer) (OptionsStruct, error) {
+	options := OptionsStruct{}
+
+	flags := flag.NewFlagSet("edit", flag.ContinueOnError)
+	flags.SetOutput(output)
+	flags.BoolVar(&options.readonly, "readonly", false, "open the file read only, editing and saving is disabled")
+	flags.StringVar(&options.encoding, "encoding", "", "character encoding of the file: utf-8, utf-16le, utf-16be, latin-1, latin-9, windows-1252 (default: detect)")
+	flags.StringVar(&options.config, "config", "", "path of the config file (default <user config dir>/edit/config)")
+	flags.Usage = func() {
+		fmt.Fprint(output, usageText)
+		flags.PrintDefaults()
+	}
+
+	// the position argument isn't a regular flag, so remove it before parsing
+	rest := make([]string, 0, len(args))
+	for _, arg := range args {
+		match := positionArg.FindStringSubmatch(arg)
+		if match == nil {
+			rest = append(rest, arg)
+			continue
+		}
+		options.line, _ = strconv.Atoi(match[1])
+		if match[2] != "" {
+			options.column, _ = strconv.Atoi(match[2])
+		}
+	}
+
+	if err := flags.Parse(rest); err != nil {
+		return options, err
+	}
+	if flags.NArg() != 1 {
+		fmt.Fprintf(output, "Missing parameter <filename>\n")
+		flags.Usage()
+		return options, errors.New("missing parameter <filename>")
+	}
+	options.filename = flags.Arg(0)
+	return options, nil
+}
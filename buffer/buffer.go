@@ -0,0 +1,175 @@
+// Package buffer holds the text of a document and edits it. It knows
+// nothing about the screen: a frontend subscribes to the changes of a buffer
+// to decide what to redraw.
+package buffer
+
+// Position is a place in the text, x is the index of a rune in line y
+type Position struct {
+	X, Y int
+}
+
+// Range is the text from From up to To, excluding To
+type Range struct {
+	From, To Position
+}
+
+type ChangeKind int
+
+const (
+	LineChanged  ChangeKind = iota
+	LineInserted            // the lines from Y on moved down
+	LineDeleted             // the lines behind Y moved up
+	TextReplaced            // the whole text, Y is 0
+)
+
+// Change tells the subscribers of a buffer which line was changed
+type Change struct {
+	Kind ChangeKind
+	Y    int
+}
+
+// Buffer is a text with its undo history. Every change of a line is
+// reported to the subscribers after it was made.
+type Buffer struct {
+	text      Text
+	Undo      UndoTree
+	listeners []func(Change)
+}
+
+// New returns a buffer of text with an empty undo history
+func New(text Text) *Buffer {
+	return &Buffer{text: text, Undo: NewUndoTree()}
+}
+
+// Subscribe calls f with every change of the buffer
+func (b *Buffer) Subscribe(f func(Change)) {
+	b.listeners = append(b.listeners, f)
+}
+
+func (b *Buffer) notify(c Change) {
+	for _, f := range b.listeners {
+		f(c)
+	}
+}
+
+// Text returns the lines of the buffer, they must be changed with the
+// methods of the buffer
+func (b *Buffer) Text() Text {
+	return b.text
+}
+
+// SetText replaces the whole text, the undo history isn't changed
+func (b *Buffer) SetText(text Text) {
+	b.text = text
+	b.notify(Change{Kind: TextReplaced})
+}
+
+func (b *Buffer) Len() int {
+	return b.text.Len()
+}
+
+func (b *Buffer) Line(y int) Line {
+	return b.text.Line(y)
+}
+
+// SetLine replaces line y, the change is recorded in e unless it is nil
+func (b *Buffer) SetLine(e *Edit, y int, line Line) {
+	if e != nil {
+		e.record(Action{Row: y, Update: true, Line: b.text.Line(y)})
+	}
+	b.text.SetLine(y, line)
+	b.notify(Change{Kind: LineChanged, Y: y})
+}
+
+// InsertLine inserts line in front of line y
+func (b *Buffer) InsertLine(e *Edit, y int, line Line) {
+	b.text.InsertLines(y, Line{})
+	b.notify(Change{Kind: LineInserted, Y: y})
+	if e != nil {
+		e.record(Action{Row: y, Insert: true, Update: true, Line: Line{}})
+	}
+	b.SetLine(e, y, line)
+}
+
+// DeleteLine deletes line y, nothing happens behind the last line
+func (b *Buffer) DeleteLine(e *Edit, y int) {
+	if y >= b.text.Len() {
+		return
+	}
+	if e != nil {
+		e.record(Action{Row: y, Delete: true, Update: true, Line: b.text.Line(y)})
+	}
+	b.text.DeleteLines(y, y+1)
+	b.notify(Change{Kind: LineDeleted, Y: y})
+}
+
+// Clamp moves a position outside of the text to the nearest position inside
+func (b *Buffer) Clamp(p Position) Position {
+	if p.Y >= b.text.Len() {
+		p.Y = b.text.Len() - 1
+		p.X = len(b.text.Line(p.Y))
+	}
+	if p.Y < 0 {
+		p = Position{}
+	}
+	if p.X > len(b.text.Line(p.Y)) {
+		p.X = len(b.text.Line(p.Y))
+	}
+	if p.X < 0 {
+		p.X = 0
+	}
+	return p
+}
+
+func concat(lines ...Line) Line {
+	line := Line{}
+	for _, l := range lines {
+		line = append(line, l...)
+	}
+	return line
+}
+
+// TextInRange returns a copy of the text in r, a line for every line of r
+func (b *Buffer) TextInRange(r Range) []Line {
+	from, to := r.From, r.To
+	if from.Y == to.Y {
+		return []Line{concat(b.text.Line(from.Y)[from.X:to.X])}
+	}
+	lines := []Line{concat(b.text.Line(from.Y)[from.X:])}
+	for y := from.Y + 1; y < to.Y; y++ {
+		lines = append(lines, concat(b.text.Line(y)))
+	}
+	return append(lines, concat(b.text.Line(to.Y)[:to.X]))
+}
+
+// Delete deletes the text in r
+func (b *Buffer) Delete(e *Edit, r Range) {
+	from, to := r.From, r.To
+	if from.Y == to.Y {
+		b.SetLine(e, from.Y, concat(b.text.Line(from.Y)[:from.X], b.text.Line(from.Y)[to.X:]))
+		return
+	}
+	b.SetLine(e, from.Y, concat(b.text.Line(from.Y)[:from.X], b.text.Line(to.Y)[to.X:]))
+	for y := from.Y + 1; y <= to.Y; y++ {
+		b.DeleteLine(e, from.Y+1)
+	}
+}
+
+// Insert inserts lines at p, the first line continues the line of p and
+// the rest of that line follows the last one. It returns the position
+// behind the inserted text.
+func (b *Buffer) Insert(e *Edit, p Position, lines []Line) Position {
+	line := b.text.Line(p.Y)
+	if len(lines) == 1 {
+		b.SetLine(e, p.Y, concat(line[:p.X], lines[0], line[p.X:]))
+		return Position{X: p.X + len(lines[0]), Y: p.Y}
+	}
+	last := len(lines) - 1
+	rest := concat(line[p.X:])
+	b.SetLine(e, p.Y, concat(line[:p.X], lines[0]))
+	for i := 1; i < last; i++ {
+		b.InsertLine(e, p.Y+i, concat(lines[i]))
+	}
+	b.InsertLine(e, p.Y+last, concat(lines[last], rest))
+	return Position{X: len(lines[last]), Y: p.Y + last}
+}
@@ -0,0 +1,100 @@
+package buffer
+
+import (
+	"fmt"
+	"testing"
+)
+
+func newTestBuffer(lines ...string) (*Buffer, *[]Change) {
+	text := NewPieceText()
+	for _, line := range lines {
+		text.InsertLines(text.Len(), Line(line))
+	}
+	b := New(text)
+	changes := &[]Change{}
+	b.Subscribe(func(c Change) { *changes = append(*changes, c) })
+	return b, changes
+}
+
+func TestEdits(t *testing.T) {
+	b, changes := newTestBuffer("one", "two", "three")
+
+	// inserting several lines splits the line of the position
+	e := Edit{CursorX: 1}
+	end := b.Insert(&e, Position{X: 1, Y: 1}, []Line{Line("a"), Line("b"), Line("c")})
+	if got := joinLines(AllLines(b.Text())); got != "one,ta,b,cwo,three" || end != (Position{X: 1, Y: 3}) {
+		t.Errorf("got %q, end %v", got, end)
+	}
+	if got := fmt.Sprint(*changes); got != "[{0 1} {1 2} {0 2} {1 3} {0 3}]" {
+		t.Errorf("changes %s", got)
+	}
+
+	r := Range{From: Position{X: 1, Y: 1}, To: end}
+	if got := joinLines(b.TextInRange(r)); got != "a,b,c" {
+		t.Errorf("text in range %q", got)
+	}
+	*changes = nil
+	b.Delete(&e, r)
+	if got := joinLines(AllLines(b.Text())); got != "one,two,three" {
+		t.Errorf("after delete %q", got)
+	}
+	if got := fmt.Sprint(*changes); got != "[{0 1} {2 2} {2 2}]" {
+		t.Errorf("changes %s", got)
+	}
+
+	if p := b.Clamp(Position{X: 9, Y: 9}); p != (Position{X: 5, Y: 2}) {
+		t.Errorf("clamped to %v", p)
+	}
+}
+
+func TestUndoTree(t *testing.T) {
+	b, changes := newTestBuffer("one", "two")
+
+	e := Edit{CursorX: 3}
+	b.SetLine(&e, 0, Line("one!"))
+	b.Undo.Push(e)
+	e = Edit{CursorX: 0}
+	b.InsertLine(&e, 1, Line("new"))
+	b.Undo.Push(e)
+	if b.Undo.Current != 2 {
+		t.Fatalf("current state %d", b.Undo.Current)
+	}
+
+	// undo reverts the changes and reports them, the cursor goes to the
+	// first change
+	*changes = nil
+	cursor := b.GotoState(0, Position{X: 2, Y: 1})
+	if got := joinLines(AllLines(b.Text())); got != "one,two" || cursor != (Position{X: 3, Y: 0}) {
+		t.Errorf("got %q, cursor %v", got, cursor)
+	}
+	if len(*changes) != 3 {
+		t.Errorf("changes %v", *changes)
+	}
+
+	// redo repeats them
+	b.GotoState(2, cursor)
+	if got := joinLines(AllLines(b.Text())); got != "one!,new,two" {
+		t.Errorf("after redo %q", got)
+	}
+
+	// mergeable changes of a line are merged, others aren't
+	e = Edit{Mergeable: true}
+	b.SetLine(&e, 1, Line("newer"))
+	b.Undo.Push(e)
+	e = Edit{Mergeable: true}
+	b.SetLine(&e, 1, Line("newest"))
+	b.Undo.Push(e)
+	if b.Undo.Current != 3 || len(b.Undo.Nodes) != 4 {
+		t.Errorf("state %d of %d", b.Undo.Current, len(b.Undo.Nodes))
+	}
+	e = Edit{}
+	b.SetLine(&e, 1, Line("other"))
+	b.Undo.Push(e)
+	if b.Undo.Current != 4 || len(b.Undo.Nodes) != 5 {
+		t.Errorf("state %d of %d after a change which isn't mergeable", b.Undo.Current, len(b.Undo.Nodes))
+	}
+	b.GotoState(2, cursor)
+	if got := string(b.Line(1)); got != "new" {
+		t.Errorf("line after undo %q", got)
+	}
+}
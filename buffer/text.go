@@ -0,0 +1,264 @@
+package buffer
+
+import (
+	"runtime"
+	"runtime/debug"
+	"sort"
+)
+
+// The lines of a buffer are stored in a piece table. The lines of the file
+// stay in the bytes read or mapped from it and are decoded when they are
+// used, so a large file takes little more memory than its size on disk.
+// Changed and inserted lines are appended to the added lines, the pieces
+// list which lines of the file and of the added lines make up the text.
+
+// Line is a line of text without its line ending
+type Line []rune
+
+// Text stores the lines of a buffer
+type Text interface {
+	Len() int
+	Line(y int) Line
+	SetLine(y int, line Line)
+	InsertLines(y int, lines ...Line)
+	DeleteLines(from, to int) // from y from up to y to, excluding to
+	// Each calls f with the lines from y on until it returns false
+	Each(y int, f func(y int, line Line) bool)
+}
+
+// AllLines returns a copy of the lines of text
+func AllLines(text Text) []Line {
+	lines := make([]Line, 0, text.Len())
+	text.Each(0, func(y int, line Line) bool {
+		lines = append(lines, line)
+		return true
+	})
+	return lines
+}
+
+// LineSlice stores the lines in a slice, every line in its own rune slice.
+// Inserting and deleting moves all lines behind.
+type LineSlice []Line
+
+func (ls *LineSlice) Len() int {
+	return len(*ls)
+}
+
+func (ls *LineSlice) Line(y int) Line {
+	return (*ls)[y]
+}
+
+func (ls *LineSlice) SetLine(y int, line Line) {
+	(*ls)[y] = line
+}
+
+func (ls *LineSlice) InsertLines(y int, lines ...Line) {
+	*ls = append((*ls)[:y], append(append(LineSlice{}, lines...), (*ls)[y:]...)...)
+}
+
+func (ls *LineSlice) DeleteLines(from, to int) {
+	*ls = append((*ls)[:from], (*ls)[to:]...)
+}
+
+func (ls *LineSlice) Each(y int, f func(y int, line Line) bool) {
+	for ; y < len(*ls); y++ {
+		if !f(y, (*ls)[y]) {
+			return
+		}
+	}
+}
+
+// FileLines are the lines of a file, decoded when they are used
+type FileLines struct {
+	Data    []byte
+	Starts  []int             // offset of every line and the end of the data
+	Decode  func([]byte) Line // gets a line with its line ending
+	Mapping interface{}       // keeps mapped data alive, nil if it was read
+	Endings []byte            // line ending of every line if the frontend keeps them
+}
+
+func (fl *FileLines) len() int {
+	return len(fl.Starts) - 1
+}
+
+// line decodes line i. Mapped data becomes inaccessible when another
+// program truncates the file, then the line is empty until the file is
+// reloaded. Other panics aren't caused by the mapping and are passed on.
+func (fl *FileLines) line(i int) (line Line) {
+	if fl.Mapping != nil {
+		defer debug.SetPanicOnFault(debug.SetPanicOnFault(true))
+		defer func() {
+			if r := recover(); r != nil {
+				// a fault is reported as a runtime error with the address
+				if _, ok := r.(interface {
+					runtime.Error
+					Addr() uintptr
+				}); !ok {
+					panic(r)
+				}
+				line = Line{}
+			}
+		}()
+	}
+	return fl.Decode(fl.Data[fl.Starts[i]:fl.Starts[i+1]])
+}
+
+// pieceStruct is a sequence of lines of the file or of the added lines
+type pieceStruct struct {
+	added        bool
+	start, count int
+}
+
+// PieceText is a piece table of lines
+type PieceText struct {
+	file   *FileLines // nil if the text wasn't read from a file
+	added  []Line
+	pieces []pieceStruct
+	firsts []int // first line of every piece
+	length int
+}
+
+// NewPieceText returns a text of lines
+func NewPieceText(lines ...Line) *PieceText {
+	pt := &PieceText{added: append([]Line{}, lines...)}
+	if len(lines) > 0 {
+		pt.pieces = []pieceStruct{{added: true, count: len(lines)}}
+	}
+	pt.update()
+	return pt
+}
+
+// NewFileText returns a text of the lines of a file
+func NewFileText(file *FileLines) *PieceText {
+	pt := &PieceText{file: file, pieces: []pieceStruct{{count: file.len()}}}
+	pt.update()
+	return pt
+}
+
+// Mapped reports whether the text refers to a file mapped into memory
+func (pt *PieceText) Mapped() bool {
+	return pt.file != nil && pt.file.Mapping != nil
+}
+
+// Mapping returns the Mapping of the file of the text, nil if it isn't mapped
+func (pt *PieceText) Mapping() interface{} {
+	if pt.file == nil {
+		return nil
+	}
+	return pt.file.Mapping
+}
+
+// update computes firsts and length after a change of the pieces
+func (pt *PieceText) update() {
+	pt.firsts = pt.firsts[:0]
+	pt.length = 0
+	for _, p := range pt.pieces {
+		pt.firsts = append(pt.firsts, pt.length)
+		pt.length += p.count
+	}
+}
+
+// find returns the index of the piece containing line y
+func (pt *PieceText) find(y int) int {
+	return sort.Search(len(pt.firsts), func(i int) bool { return pt.firsts[i] > y }) - 1
+}
+
+func (pt *PieceText) pieceLine(p pieceStruct, i int) Line {
+	if p.added {
+		return pt.added[p.start+i]
+	}
+	return pt.file.line(p.start + i)
+}
+
+// Ending returns the line ending of line y in the file, false if the line
+// was changed or inserted or the file has no Endings
+func (pt *PieceText) Ending(y int) (byte, bool) {
+	if pt.file == nil || pt.file.Endings == nil {
+		return 0, false
+	}
+	i := pt.find(y)
+	if p := pt.pieces[i]; !p.added {
+		return pt.file.Endings[p.start+y-pt.firsts[i]], true
+	}
+	return 0, false
+}
+
+func (pt *PieceText) Len() int {
+	return pt.length
+}
+
+func (pt *PieceText) Line(y int) Line {
+	i := pt.find(y)
+	return pt.pieceLine(pt.pieces[i], y-pt.firsts[i])
+}
+
+func (pt *PieceText) SetLine(y int, line Line) {
+	i := pt.find(y)
+	if p := pt.pieces[i]; p.added {
+		// added lines aren't shared, they can be changed in place
+		pt.added[p.start+y-pt.firsts[i]] = line
+		return
+	}
+	pt.DeleteLines(y, y+1)
+	pt.InsertLines(y, line)
+}
+
+// split makes line y the first line of a piece and returns its index
+func (pt *PieceText) split(y int) int {
+	if y >= pt.length {
+		return len(pt.pieces)
+	}
+	i := pt.find(y)
+	offset := y - pt.firsts[i]
+	if offset == 0 {
+		return i
+	}
+	p := pt.pieces[i]
+	before := pieceStruct{added: p.added, start: p.start, count: offset}
+	after := pieceStruct{added: p.added, start: p.start + offset, count: p.count - offset}
+	pt.pieces = append(pt.pieces[:i+1], pt.pieces[i:]...)
+	pt.pieces[i], pt.pieces[i+1] = before, after
+	pt.update()
+	return i + 1
+}
+
+func (pt *PieceText) InsertLines(y int, lines ...Line) {
+	if len(lines) == 0 {
+		return
+	}
+	start := len(pt.added)
+	pt.added = append(pt.added, lines...)
+	i := pt.split(y)
+	if i > 0 && pt.pieces[i-1].added && pt.pieces[i-1].start+pt.pieces[i-1].count == start {
+		// lines typed one after the other continue the piece before
+		pt.pieces[i-1].count += len(lines)
+	} else {
+		pt.pieces = append(pt.pieces[:i], append([]pieceStruct{{added: true, start: start, count: len(lines)}}, pt.pieces[i:]...)...)
+	}
+	pt.update()
+}
+
+func (pt *PieceText) DeleteLines(from, to int) {
+	if from >= to {
+		return
+	}
+	i := pt.split(from)
+	j := pt.split(to)
+	pt.pieces = append(pt.pieces[:i], pt.pieces[j:]...)
+	pt.update()
+}
+
+func (pt *PieceText) Each(y int, f func(y int, line Line) bool) {
+	if y >= pt.length {
+		return
+	}
+	i := pt.find(y)
+	for offset := y - pt.firsts[i]; i < len(pt.pieces); i, offset = i+1, 0 {
+		p := pt.pieces[i]
+		for ; offset < p.count; offset++ {
+			if !f(pt.firsts[i]+offset, pt.pieceLine(p, offset)) {
+				return
+			}
+		}
+	}
+}
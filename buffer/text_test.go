@@ -0,0 +1,80 @@
+package buffer
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func joinLines(lines []Line) string {
+	var s []string
+	for _, line := range lines {
+		s = append(s, string(line))
+	}
+	return strings.Join(s, ",")
+}
+
+func TestPieceText(t *testing.T) {
+	data := []byte("zero\none\ntwo\nthree\n\nfive")
+	file := &FileLines{Data: data, Starts: []int{0, 5, 9, 13, 19, 20, len(data)}}
+	file.Decode = func(b []byte) Line { return Line(strings.TrimSuffix(string(b), "\n")) }
+	text := NewFileText(file)
+	lines := LineSlice(AllLines(text))
+	if got := joinLines(lines); got != "zero,one,two,three,,five" {
+		t.Fatalf("lines %q", got)
+	}
+
+	// random changes give the same lines as in a slice
+	random := rand.New(rand.NewSource(1))
+	for i := 0; i < 2000; i++ {
+		y := random.Intn(lines.Len() + 1)
+		line := Line(fmt.Sprint(i))
+		switch op := random.Intn(3); {
+		case op == 0 && y < lines.Len():
+			text.SetLine(y, line)
+			lines.SetLine(y, line)
+		case op == 1 || lines.Len() < 3:
+			text.InsertLines(y, line, line)
+			lines.InsertLines(y, line, line)
+		default:
+			to := y + random.Intn(3)
+			if to > lines.Len() {
+				to = lines.Len()
+			}
+			text.DeleteLines(y, to)
+			lines.DeleteLines(y, to)
+		}
+		if text.Len() != lines.Len() {
+			t.Fatalf("step %d: %d lines, expected %d", i, text.Len(), lines.Len())
+		}
+		y = random.Intn(lines.Len())
+		if string(text.Line(y)) != string(lines.Line(y)) {
+			t.Fatalf("step %d: line %d is %q, expected %q", i, y, string(text.Line(y)), string(lines.Line(y)))
+		}
+	}
+	if joinLines(AllLines(text)) != joinLines(lines) {
+		t.Errorf("texts differ after the changes")
+	}
+	count := 0
+	text.Each(lines.Len()-3, func(y int, line Line) bool {
+		count++
+		return string(line) == string(lines.Line(y))
+	})
+	if count != 3 {
+		t.Errorf("each stopped after %d lines", count)
+	}
+}
+
+func TestMappedPanic(t *testing.T) {
+	// only memory faults of the mapping are caught
+	file := &FileLines{Data: []byte("x\n"), Starts: []int{0, 2}, Mapping: struct{}{}}
+	file.Decode = func(b []byte) Line { panic("decoding failed") }
+	defer func() {
+		if r := recover(); r != "decoding failed" {
+			t.Errorf("recovered %v", r)
+		}
+	}()
+	file.line(0)
+	t.Errorf("the panic was caught")
+}
@@ -0,0 +1,216 @@
+package buffer
+
+import "time"
+
+// Action is the change of one line, with the line before the change
+type Action struct {
+	Row     int
+	Delete  bool
+	Insert  bool
+	Update  bool
+	Line    Line
+	CursorX int
+}
+
+// Edit collects the actions of one change, they are undone together.
+// CursorX is recorded with every action, undo puts the cursor there.
+// Mergeable edits of the same line, like typed characters, are undone
+// together too.
+type Edit struct {
+	Actions   []Action
+	CursorX   int
+	Mergeable bool
+}
+
+func (e *Edit) record(action Action) {
+	action.CursorX = e.CursorX
+	e.Actions = append(e.Actions, action)
+}
+
+// UndoNode is a state of the document in the undo tree. The root node is
+// the document as it was loaded, every edit adds a child to the current node.
+type UndoNode struct {
+	Parent      int
+	Children    []int
+	ActiveChild int  // index in children followed by redo
+	Undo        Edit // reverts the change from parent to this node
+	Redo        Edit // repeats the change, set when the node is undone
+	Time        time.Time
+}
+
+// UndoTree keeps every state of the document. Editing after undo starts a
+// new branch instead of dropping the undone changes. Nodes are appended in
+// the order they are created, so the node index is also a sequence number.
+type UndoTree struct {
+	Nodes   []UndoNode
+	Current int
+	Saved   int // node of the text in the file
+	Changes int // counts pushes, undos and redos, to notice changes
+}
+
+func NewUndoTree() UndoTree {
+	return UndoTree{
+		Nodes:   []UndoNode{{Parent: -1, Time: time.Now()}},
+		Current: 0,
+	}
+}
+
+// Push adds the state after e, mergeable changes of the same line are merged
+func (ut *UndoTree) Push(e Edit) {
+	if len(e.Actions) == 0 {
+		return
+	}
+	ut.Changes++
+	if ut.merge(e) {
+		ut.Nodes[ut.Current].Time = time.Now()
+		return
+	}
+	node := UndoNode{
+		Parent: ut.Current,
+		Undo:   e,
+		Time:   time.Now(),
+	}
+	ut.Nodes = append(ut.Nodes, node)
+	index := len(ut.Nodes) - 1
+	parent := &ut.Nodes[ut.Current]
+	parent.Children = append(parent.Children, index)
+	parent.ActiveChild = len(parent.Children) - 1
+	ut.Current = index
+}
+
+func (ut *UndoTree) merge(e Edit) bool {
+	if !e.Mergeable {
+		return false // like a paste or a replacement, an undo step of its own
+	}
+	if len(e.Actions) > 1 {
+		return false // multiple actions... can't merge
+	}
+	action := e.Actions[0]
+	if action.Insert || action.Delete {
+		return false // insert or delete... can't merge
+	}
+	// get previous undo item
+	if ut.Current <= 0 {
+		return false // no unto items available
+	}
+	if ut.Current == ut.Saved {
+		return false // keep the state which was saved
+	}
+	if len(ut.Nodes[ut.Current].Children) > 0 {
+		return false // previous item was undone before... keep the branch
+	}
+	prevEdit := ut.Nodes[ut.Current].Undo
+	if !prevEdit.Mergeable {
+		return false
+	}
+	if len(prevEdit.Actions) > 1 {
+		return false // multiple actions... can't merge
+	}
+	prevAction := prevEdit.Actions[0]
+	if prevAction.Insert || prevAction.Delete {
+		return false // insert or delete... can't merge
+	}
+	if action.Row != prevAction.Row {
+		return false // different rows affected... can't merge
+	}
+	// not required to save a new undo item (single updates on same row)
+	return true
+}
+
+// path returns the nodes to undo and to redo to get from the current node to target
+func (ut *UndoTree) path(target int) (undo []int, redo []int) {
+	// nodes from target up to the root
+	ancestors := map[int]bool{}
+	for n := target; n >= 0; n = ut.Nodes[n].Parent {
+		ancestors[n] = true
+	}
+	// undo until the common ancestor is reached
+	n := ut.Current
+	for !ancestors[n] {
+		undo = append(undo, n)
+		n = ut.Nodes[n].Parent
+	}
+	// redo from the common ancestor down to target
+	for t := target; t != n; t = ut.Nodes[t].Parent {
+		redo = append([]int{t}, redo...)
+	}
+	return undo, redo
+}
+
+// StateAt returns the newest state which existed at time t
+func (ut *UndoTree) StateAt(t time.Time) int {
+	state := 0
+	for n := range ut.Nodes {
+		if !ut.Nodes[n].Time.After(t) {
+			state = n
+		}
+	}
+	return state
+}
+
+// StateBySteps returns the state count steps earlier (negative) or later
+// in the order the states were created, regardless of branches
+func (ut *UndoTree) StateBySteps(count int) int {
+	target := ut.Current + count
+	if target < 0 {
+		target = 0
+	}
+	if target >= len(ut.Nodes) {
+		target = len(ut.Nodes) - 1
+	}
+	return target
+}
+
+// apply reverts the actions of e and returns the edit which reverts this
+// again, cursor is set to the first action
+func (b *Buffer) apply(e Edit, cursor *Position) Edit {
+	inverse := Edit{}
+	for i := len(e.Actions) - 1; i >= 0; i-- {
+		action := e.Actions[i]
+		*cursor = Position{X: action.CursorX, Y: action.Row}
+		inverse.CursorX = action.CursorX
+		if action.Delete {
+			b.InsertLine(&inverse, action.Row, action.Line)
+		} else if action.Insert {
+			b.DeleteLine(&inverse, action.Row)
+		} else if action.Update {
+			b.SetLine(&inverse, action.Row, action.Line)
+		}
+	}
+	return inverse
+}
+
+func (b *Buffer) undoNode(n int, cursor *Position) {
+	ut := &b.Undo
+	ut.Nodes[n].Redo = b.apply(ut.Nodes[n].Undo, cursor)
+	ut.Current = ut.Nodes[n].Parent
+	ut.Changes++
+	// redo follows the branch which was undone last
+	parent := &ut.Nodes[ut.Current]
+	for i, child := range parent.Children {
+		if child == n {
+			parent.ActiveChild = i
+		}
+	}
+}
+
+func (b *Buffer) redoNode(n int, cursor *Position) {
+	ut := &b.Undo
+	ut.Nodes[n].Undo = b.apply(ut.Nodes[n].Redo, cursor)
+	ut.Current = n
+	ut.Changes++
+}
+
+// GotoState undoes and redoes changes until the text is in the state of
+// node target. It returns the cursor at the place of the last change, which
+// may be behind the last line, or cursor if nothing was changed.
+func (b *Buffer) GotoState(target int, cursor Position) Position {
+	undo, redo := b.Undo.path(target)
+	for _, n := range undo {
+		b.undoNode(n, &cursor)
+	}
+	for _, n := range redo {
+		b.redoNode(n, &cursor)
+	}
+	return cursor
+}
@@ -0,0 +1,371 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+	"jostermeier.de/edit/buffer"
+)
+
+// KillRingStruct keeps the last copied or cut texts, the newest first
+type KillRingStruct struct {
+	entries [][]LineType
+	size    int
+}
+
+// killRing is shared by all documents
+var killRing = KillRingStruct{size: 10}
+
+func (kr *KillRingStruct) push(lines []LineType) {
+	kr.entries = append([][]LineType{lines}, kr.entries...)
+	size := kr.size
+	if size < 1 {
+		// killring_size is too small, keep the last entry for paste
+		size = 1
+	}
+	if len(kr.entries) > size {
+		kr.entries = kr.entries[:size]
+	}
+}
+
+// get returns the entry i, counting from the newest entry
+func (kr *KillRingStruct) get(i int) ([]LineType, bool) {
+	if i < 0 || i >= len(kr.entries) {
+		return nil, false
+	}
+	return kr.entries[i], true
+}
+
+// PasteStruct remembers the last paste, so it can be replaced by an older entry
+type PasteStruct struct {
+	from, to xyStruct
+	index    int
+}
+
+func (doc *DocStruct) handleEventCopy() {
+	if doc.selection == emptySelection {
+		doc.setStatus("nothing selected")
+		return
+	}
+	lines := doc.textInRange(doc.selectionRange())
+	doc.setStatus("copied %d lines", len(lines))
+	doc.copyToClipboard(lines)
+}
+
+func (doc *DocStruct) handleEventCut() {
+	if doc.selection == emptySelection {
+		doc.setStatus("nothing selected")
+		return
+	}
+	doc.copyToClipboard(doc.textInRange(doc.selectionRange()))
+
+	undoItem := buffer.Edit{}
+	doc.deleteSelection(&undoItem)
+	doc.Undo.Push(undoItem)
+	doc.adjustViewport()
+}
+
+func (doc *DocStruct) handleEventPaste() {
+	lines, ok := doc.pasteFromClipboard()
+	if !ok {
+		doc.setStatus("clipboard is empty")
+		return
+	}
+	undoItem := buffer.Edit{}
+	doc.deleteSelection(&undoItem)
+	cursor := xyStruct{x: doc.absolutCursor.x, y: doc.absolutCursor.y}
+	doc.lastPaste = doc.paste(&undoItem, cursor, lines, 0)
+	doc.Undo.Push(undoItem)
+	doc.adjustViewport()
+}
+
+// handleEventPasteCycle replaces the text pasted before with the next older
+// entry of the kill ring
+func (doc *DocStruct) handleEventPasteCycle(lastPaste *PasteStruct) {
+	if lastPaste == nil {
+		doc.setStatus("paste first, then cycle through older entries")
+		return
+	}
+	index := lastPaste.index + 1
+	lines, ok := killRing.get(index)
+	if !ok {
+		// start again with the newest entry
+		index = 0
+		lines, _ = killRing.get(index)
+	}
+	undoItem := buffer.Edit{}
+	doc.deleteRange(&undoItem, lastPaste.from, lastPaste.to)
+	doc.lastPaste = doc.paste(&undoItem, lastPaste.from, lines, index)
+	doc.Undo.Push(undoItem)
+	doc.adjustViewport()
+	doc.setStatus("pasted entry %d of %d", index+1, len(killRing.entries))
+}
+
+// paste inserts lines at from and moves the cursor behind them
+func (doc *DocStruct) paste(ui *buffer.Edit, from xyStruct, lines []LineType, index int) *PasteStruct {
+	to := doc.insertText(ui, from, lines)
+	doc.absolutCursor.x = to.x
+	doc.absolutCursor.y = to.y
+	doc.updateWantX()
+	return &PasteStruct{from: from, to: to, index: index}
+}
+
+// ClipboardInterface connects copy and paste to the clipboard of the system
+type ClipboardInterface interface {
+	name() string
+	copy(text string) error
+	// paste returns errClipboardNoPaste if the clipboard can't be read
+	paste() (string, error)
+}
+
+// systemClipboard is selected at startup, nil if only the kill ring is used
+var systemClipboard ClipboardInterface
+
+var errClipboardNoPaste = errors.New("clipboard can't be read")
+
+// osc52ClipboardStruct sets the clipboard of the terminal emulator with the
+// OSC 52 escape sequence, this works over ssh as well. Reading the clipboard
+// isn't supported by most terminals, paste uses the kill ring.
+type osc52ClipboardStruct struct {
+	terminal io.Writer
+}
+
+func (c *osc52ClipboardStruct) name() string {
+	return "osc52"
+}
+
+func (c *osc52ClipboardStruct) copy(text string) error {
+	sequence := "\x1b]52;c;" + base64.StdEncoding.EncodeToString([]byte(text)) + "\a"
+	if os.Getenv("TMUX") != "" {
+		// tmux passes the sequence on to the outer terminal
+		sequence = "\x1bPtmux;" + strings.ReplaceAll(sequence, "\x1b", "\x1b\x1b") + "\x1b\\"
+	}
+	_, err := io.WriteString(c.terminal, sequence)
+	return err
+}
+
+func (c *osc52ClipboardStruct) paste() (string, error) {
+	return "", errClipboardNoPaste
+}
+
+// commandClipboardStruct runs external programs like xclip, wl-copy or pbcopy
+type commandClipboardStruct struct {
+	copyCommand  []string
+	pasteCommand []string
+}
+
+const clipboardTimeout = 2 * time.Second
+
+func (c *commandClipboardStruct) name() string {
+	return c.copyCommand[0]
+}
+
+// copy runs the copy command. Tools like xclip fork a child which keeps
+// serving the clipboard and inherits the files of the command. Wait would
+// wait for the child to close a pipe, so the text is passed in a file and
+// the output isn't read.
+func (c *commandClipboardStruct) copy(text string) error {
+	input, err := os.CreateTemp("", "edit-clipboard-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(input.Name())
+	defer input.Close()
+	if _, err := io.WriteString(input, text); err != nil {
+		return err
+	}
+	if _, err := input.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), clipboardTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, c.copyCommand[0], c.copyCommand[1:]...)
+	cmd.Stdin = input
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("%s: %v", c.copyCommand[0], err)
+	}
+	if err := cmd.Wait(); err != nil {
+		return fmt.Errorf("%s: %v", c.copyCommand[0], err)
+	}
+	return nil
+}
+
+func (c *commandClipboardStruct) paste() (string, error) {
+	if len(c.pasteCommand) == 0 {
+		return "", errClipboardNoPaste
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), clipboardTimeout)
+	defer cancel()
+	output, err := exec.CommandContext(ctx, c.pasteCommand[0], c.pasteCommand[1:]...).Output()
+	if err != nil {
+		return "", fmt.Errorf("%s: %v", c.pasteCommand[0], err)
+	}
+	return string(output), nil
+}
+
+// selectClipboard chooses the clipboard by the config key clipboard:
+// none, osc52, command (clipboard_copy and clipboard_paste) or auto
+func selectClipboard(terminal io.Writer) (ClipboardInterface, error) {
+	command := func() ClipboardInterface {
+		copyCommand := strings.Fields(config.get("clipboard_copy", ""))
+		if len(copyCommand) == 0 {
+			return nil
+		}
+		return &commandClipboardStruct{
+			copyCommand:  copyCommand,
+			pasteCommand: strings.Fields(config.get("clipboard_paste", "")),
+		}
+	}
+	osc52 := func() ClipboardInterface {
+		if terminal == nil {
+			return nil
+		}
+		return &osc52ClipboardStruct{terminal: terminal}
+	}
+
+	switch mode := config.get("clipboard", "auto"); mode {
+	case "none":
+		return nil, nil
+	case "osc52":
+		if c := osc52(); c != nil {
+			return c, nil
+		}
+		return nil, errors.New("clipboard osc52: terminal not available")
+	case "command":
+		if c := command(); c != nil {
+			return c, nil
+		}
+		return nil, errors.New("clipboard command: clipboard_copy is not set")
+	case "auto":
+		if c := command(); c != nil {
+			return c, nil
+		}
+		if os.Getenv("SSH_TTY") == "" {
+			// local session, use the clipboard tools of the desktop
+			for _, tool := range []struct {
+				env          string
+				copyCommand  []string
+				pasteCommand []string
+			}{
+				{"WAYLAND_DISPLAY", []string{"wl-copy"}, []string{"wl-paste", "--no-newline"}},
+				{"DISPLAY", []string{"xclip", "-selection", "clipboard"}, []string{"xclip", "-selection", "clipboard", "-o"}},
+				{"DISPLAY", []string{"xsel", "--clipboard", "--input"}, []string{"xsel", "--clipboard", "--output"}},
+				{"", []string{"pbcopy"}, []string{"pbpaste"}},
+			} {
+				if tool.env != "" && os.Getenv(tool.env) == "" {
+					continue
+				}
+				if _, err := exec.LookPath(tool.copyCommand[0]); err != nil {
+					continue
+				}
+				return &commandClipboardStruct{copyCommand: tool.copyCommand, pasteCommand: tool.pasteCommand}, nil
+			}
+		}
+		return osc52(), nil
+	default:
+		return nil, fmt.Errorf("unknown clipboard %q", mode)
+	}
+}
+
+func linesToText(lines []LineType) string {
+	var builder strings.Builder
+	for i, line := range lines {
+		if i > 0 {
+			builder.WriteByte('\n')
+		}
+		builder.Write(encodeUTF8Line(line))
+	}
+	return builder.String()
+}
+
+func textToLines(text string) []LineType {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	text = strings.ReplaceAll(text, "\r", "\n")
+	lines := []LineType{}
+	for _, line := range strings.Split(text, "\n") {
+		lines = append(lines, decodeUTF8Line([]byte(line)))
+	}
+	return lines
+}
+
+// copyToClipboard puts lines into the kill ring and the system clipboard
+func (doc *DocStruct) copyToClipboard(lines []LineType) {
+	killRing.push(lines)
+	if systemClipboard == nil {
+		return
+	}
+	if err := systemClipboard.copy(linesToText(lines)); err != nil {
+		doc.setError("clipboard: %v", err)
+	}
+}
+
+// pasteFromClipboard returns the system clipboard if it can be read and
+// puts it into the kill ring, otherwise the newest kill ring entry
+func (doc *DocStruct) pasteFromClipboard() ([]LineType, bool) {
+	if systemClipboard != nil {
+		text, err := systemClipboard.paste()
+		if err == nil {
+			newest, ok := killRing.get(0)
+			if !ok || linesToText(newest) != text {
+				killRing.push(textToLines(text))
+			}
+		} else if err != errClipboardNoPaste {
+			doc.setError("clipboard: %v", err)
+		}
+	}
+	return killRing.get(0)
+}
+
+// bufferPasteKey collects the keys of a bracketed paste, they are inserted
+// as one block when the paste ends
+func (doc *DocStruct) bufferPasteKey(event *tcell.EventKey) {
+	switch event.Key() {
+	case tcell.KeyRune:
+		doc.pasteBuffer.WriteRune(event.Rune())
+	case tcell.KeyEnter:
+		doc.pasteBuffer.WriteByte('\r')
+	case tcell.KeyLF:
+		doc.pasteBuffer.WriteByte('\n')
+	case tcell.KeyTab:
+		doc.pasteBuffer.WriteByte('\t')
+	}
+}
+
+// handleEventPasteText inserts pasted text verbatim, without auto indent or
+// tab expansion, as a single undo item
+func (doc *DocStruct) handleEventPasteText(text string) {
+	if text == "" {
+		return
+	}
+	lines := textToLines(text)
+	if doc.prompt != nil {
+		// only the first line goes into the prompt
+		prompt := doc.prompt
+		prompt.input = concatenateLines(prompt.input[:prompt.cursor], lines[0], prompt.input[prompt.cursor:])
+		prompt.cursor += len(lines[0])
+		doc.renderStatusLine()
+		if prompt.onChange != nil {
+			prompt.onChange(string(prompt.input))
+		}
+		return
+	}
+	if doc.readonly {
+		doc.screen.Beep()
+		return
+	}
+	undoItem := buffer.Edit{}
+	doc.deleteSelection(&undoItem)
+	cursor := xyStruct{x: doc.absolutCursor.x, y: doc.absolutCursor.y}
+	doc.paste(&undoItem, cursor, lines, 0)
+	doc.Undo.Push(undoItem)
+	doc.adjustViewport()
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+// fileClipboardStruct stands in for the system clipboard
+type fileClipboardStruct struct {
+	path string
+}
+
+func (c *fileClipboardStruct) name() string {
+	return "file"
+}
+
+func (c *fileClipboardStruct) copy(text string) error {
+	return os.WriteFile(c.path, []byte(text), 0600)
+}
+
+func (c *fileClipboardStruct) paste() (string, error) {
+	data, err := os.ReadFile(c.path)
+	return string(data), err
+}
+
+func TestSystemClipboard(t *testing.T) {
+	clipboard := &fileClipboardStruct{path: filepath.Join(t.TempDir(), "clipboard")}
+	systemClipboard = clipboard
+	defer func() { systemClipboard = nil }()
+	killRing = KillRingStruct{size: 10}
+
+	doc := newTestDoc(t, "one", "two")
+	doc.selection = selectionStruct{begin: xyStruct{x: 1, y: 0}, end: xyStruct{x: 0, y: 1}}
+	doc.handleEventCopy()
+	if text, _ := clipboard.paste(); text != "ne\nt" {
+		t.Fatalf("Error %q", text)
+	}
+
+	// another program changed the clipboard
+	clipboard.copy("x\r\ny")
+	doc.selection = emptySelection
+	doc.handleEventPaste()
+	if docText(doc) != "x\nyone\ntwo" {
+		t.Fatalf("Error %q", docText(doc))
+	}
+	if len(killRing.entries) != 2 {
+		t.Fatalf("Error %d", len(killRing.entries))
+	}
+}
+
+func TestCommandClipboard(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs a shell")
+	}
+	// like xclip the command leaves a child behind which keeps its output open
+	dir := t.TempDir()
+	script := filepath.Join(dir, "copy")
+	clipboard := filepath.Join(dir, "clipboard")
+	if err := os.WriteFile(script, []byte("#!/bin/sh\ncat > \"$1\"\nsleep 5 &\necho copied\n"), 0755); err != nil {
+		t.Fatalf("Error %v", err)
+	}
+	c := &commandClipboardStruct{copyCommand: []string{script, clipboard}}
+	start := time.Now()
+	if err := c.copy("hi"); err != nil {
+		t.Fatalf("Error %v", err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("copy waited %v for the child", d)
+	}
+	if data, _ := os.ReadFile(clipboard); string(data) != "hi" {
+		t.Errorf("Error %q", data)
+	}
+}
+
+func TestOsc52Clipboard(t *testing.T) {
+	t.Setenv("TMUX", "")
+	var terminal bytes.Buffer
+	clipboard := osc52ClipboardStruct{terminal: &terminal}
+	if err := clipboard.copy("hi"); err != nil {
+		t.Fatalf("Error %v", err)
+	}
+	if terminal.String() != "\x1b]52;c;aGk=\a" {
+		t.Fatalf("Error %q", terminal.String())
+	}
+	if _, err := clipboard.paste(); err != errClipboardNoPaste {
+		t.Fatalf("Error %v", err)
+	}
+}
+
+func TestBracketedPaste(t *testing.T) {
+	doc := newTestDoc(t, "ab")
+	doc.absolutCursor.x = 1
+	doc.pasteBuffer = &strings.Builder{}
+	for _, event := range []*tcell.EventKey{
+		tcell.NewEventKey(tcell.KeyTab, 0, tcell.ModNone),
+		tcell.NewEventKey(tcell.KeyRune, 'x', tcell.ModNone),
+		tcell.NewEventKey(tcell.KeyEnter, 0, tcell.ModNone),
+		tcell.NewEventKey(tcell.KeyRune, ' ', tcell.ModNone),
+		tcell.NewEventKey(tcell.KeyRune, 'y', tcell.ModNone),
+	} {
+		doc.bufferPasteKey(event)
+	}
+	text := doc.pasteBuffer.String()
+	doc.pasteBuffer = nil
+	doc.handleEventPasteText(text)
+	if docText(doc) != "a\tx\n yb" {
+		t.Fatalf("Error %q", docText(doc))
+	}
+	if doc.absolutCursor.x != 2 || doc.absolutCursor.y != 1 {
+		t.Fatalf("Error %v", doc.absolutCursor)
+	}
+	// the paste is undone at once
+	doc.handleEventUndo()
+	if docText(doc) != "ab" {
+		t.Fatalf("Error %q", docText(doc))
+	}
+
+	// a paste of one line after typing on it is undone on its own
+	doc.absolutCursor = CursorStruct{x: 2, y: 0}
+	typeString(doc, "X")
+	doc.handleEventPasteText("a")
+	doc.handleEventUndo()
+	if docText(doc) != "abX" {
+		t.Fatalf("Error %q", docText(doc))
+	}
+}
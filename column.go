@@ -0,0 +1,166 @@
+package main
+
+import (
+	"unicode/utf8"
+
+	"github.com/mattn/go-runewidth"
+	"github.com/rivo/uniseg"
+)
+
+// The cursor is a rune index in its line, the screen is made of columns. A
+// tab reaches to the next tab stop, wide characters take two columns and
+// combining characters none, the functions below convert between them.
+// The cursor moves by grapheme clusters and never stops inside one.
+// wantX of the cursor is a column, so moving up and down keeps the cursor
+// above the same place.
+
+const defaultTabWidth = 4
+
+func (doc *DocStruct) tabSize() int {
+	if doc.tabWidth <= 0 {
+		return defaultTabWidth
+	}
+	return doc.tabWidth
+}
+
+// eachCluster calls f with the index of the first rune of every grapheme
+// cluster of line and the index behind it, until f returns false. A cluster
+// is what the user sees as one character, like a letter with combining
+// accents or an emoji made of several code points. Below the combining
+// diacritical marks every rune is a cluster, only the rest of the line from
+// the first rune which may join its neighbour is segmented, so lines of
+// plain text cost nothing.
+func eachCluster(line LineType, f func(from, to int) bool) {
+	for x := 0; x < len(line); x++ {
+		if line[x] >= 0x300 || x+1 < len(line) && line[x+1] >= 0x300 {
+			g := uniseg.NewGraphemes(string(line[x:]))
+			for g.Next() {
+				n := len(g.Runes())
+				if !f(x, x+n) {
+					return
+				}
+				x += n
+			}
+			return
+		}
+		if !f(x, x+1) {
+			return
+		}
+	}
+}
+
+// clusterBounds returns the index of the first rune of every grapheme
+// cluster of line, followed by the length of line
+func clusterBounds(line LineType) []int {
+	bounds := make([]int, 0, len(line)+1)
+	eachCluster(line, func(from, to int) bool {
+		bounds = append(bounds, from)
+		return true
+	})
+	return append(bounds, len(line))
+}
+
+// clusterWidth returns the number of columns cluster takes at column col
+func (doc *DocStruct) clusterWidth(cluster LineType, col int) int {
+	if cluster[0] == '\t' {
+		return doc.tabSize() - col%doc.tabSize()
+	}
+	for _, r := range cluster {
+		if isRawByte(r) {
+			r = utf8.RuneError
+		}
+		if w := runewidth.RuneWidth(r); w > 0 {
+			return w
+		}
+	}
+	return 1 // combining marks only, drawn on a space
+}
+
+// column returns the column of the rune at index x of line
+func (doc *DocStruct) column(line LineType, x int) int {
+	col := 0
+	eachCluster(line, func(from, to int) bool {
+		if from >= x {
+			return false
+		}
+		col += doc.clusterWidth(line[from:to], col)
+		return true
+	})
+	return col
+}
+
+// runeIndex returns the index of the first rune of the cluster of line at
+// column col, a column inside a tab or a wide character belongs to it
+func (doc *DocStruct) runeIndex(line LineType, col int) int {
+	c := 0
+	x := len(line)
+	eachCluster(line, func(from, to int) bool {
+		c += doc.clusterWidth(line[from:to], c)
+		if c > col {
+			x = from
+			return false
+		}
+		return true
+	})
+	return x
+}
+
+// nextCluster returns the index of the cluster behind the one at x
+func nextCluster(line LineType, x int) int {
+	next := len(line)
+	eachCluster(line, func(from, to int) bool {
+		if from > x {
+			next = from
+			return false
+		}
+		return true
+	})
+	return next
+}
+
+// prevCluster returns the index of the cluster before the one at x
+func prevCluster(line LineType, x int) int {
+	prev := 0
+	eachCluster(line, func(from, to int) bool {
+		if from >= x {
+			return false
+		}
+		prev = from
+		return true
+	})
+	return prev
+}
+
+// clusterStart returns the index of the first rune of the cluster
+// containing the rune at x
+func clusterStart(line LineType, x int) int {
+	if x >= len(line) {
+		return len(line)
+	}
+	return prevCluster(line, x+1)
+}
+
+// cursorColumn returns the column of the cursor in its line, in wrap mode
+// the column in its row
+func (doc *DocStruct) cursorColumn() int {
+	line := doc.Line(doc.absolutCursor.y)
+	if doc.wrap != WrapOff {
+		_, col := doc.wrapPosition(line, doc.absolutCursor.x)
+		return col
+	}
+	return doc.column(line, doc.absolutCursor.x)
+}
+
+// updateWantX keeps the cursor inside its line at the start of a cluster
+// and remembers its column
+func (doc *DocStruct) updateWantX() {
+	line := doc.Line(doc.absolutCursor.y)
+	if doc.absolutCursor.x > len(line) {
+		doc.absolutCursor.x = len(line)
+	}
+	if doc.absolutCursor.x < 0 {
+		doc.absolutCursor.x = 0
+	}
+	doc.absolutCursor.x = clusterStart(line, doc.absolutCursor.x)
+	doc.absolutCursor.wantX = doc.cursorColumn()
+}
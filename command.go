@@ -0,0 +1,391 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// CommandStruct describes a command that can be entered in the command prompt
+type CommandStruct struct {
+	usage    string
+	modifies bool // not allowed in read only documents
+	execute  func(doc *DocStruct, args []string) error
+}
+
+var commands map[string]CommandStruct
+
+func init() {
+	commands = map[string]CommandStruct{
+		"help": {
+			usage:   "help - list all commands",
+			execute: executeHelp,
+		},
+		"lineending": {
+			usage:    "lineending lf|crlf|cr - convert the line endings of the document",
+			modifies: true,
+			execute:  executeLineEnding,
+		},
+		"bom": {
+			usage:    "bom on|off - write the document with or without byte order mark",
+			modifies: true,
+			execute:  executeBom,
+		},
+		"encoding": {
+			usage:    "encoding NAME - save the document in another encoding",
+			modifies: true,
+			execute:  executeEncoding,
+		},
+		"earlier": {
+			usage:    "earlier N|DURATION - go back N states or DURATION (like 5m) in time, across undo branches",
+			modifies: true,
+			execute:  executeEarlier,
+		},
+		"later": {
+			usage:    "later N|DURATION - go forward N states or DURATION in time, across undo branches",
+			modifies: true,
+			execute:  executeLater,
+		},
+		"undostate": {
+			usage:    "undostate [N] - show the current undo state or go to state N",
+			modifies: true,
+			execute:  executeUndoState,
+		},
+		"redobranch": {
+			usage: "redobranch - select the next branch followed by redo",
+			execute: func(doc *DocStruct, args []string) error {
+				doc.handleEventRedoBranch()
+				return nil
+			},
+		},
+		"replace": {
+			usage:    "replace - replace a regexp in the selection or document, asking for each match",
+			modifies: true,
+			execute: func(doc *DocStruct, args []string) error {
+				doc.handleEventReplace()
+				return nil
+			},
+		},
+		"count": {
+			usage: "count - count the matches of a regexp in the selection or document",
+			execute: func(doc *DocStruct, args []string) error {
+				doc.handleEventCountMatches()
+				return nil
+			},
+		},
+		"grep": {
+			usage:   "grep [TEXT] - search all files below the working directory, respecting .gitignore",
+			execute: executeGrep,
+		},
+		"open": {
+			usage: "open [FILE] - open a file in a new document",
+			execute: func(doc *DocStruct, args []string) error {
+				if len(args) == 0 {
+					editor.handleEventOpen()
+					return nil
+				}
+				_, err := editor.openDoc(strings.Join(args, " "))
+				return err
+			},
+		},
+		"buffers": {
+			usage: "buffers - switch to another open document",
+			execute: func(doc *DocStruct, args []string) error {
+				editor.handleEventSwitchDoc()
+				return nil
+			},
+		},
+		"close": {
+			usage: "close - close the document, asking to save unsaved changes",
+			execute: func(doc *DocStruct, args []string) error {
+				editor.handleEventCloseDoc(doc)
+				return nil
+			},
+		},
+		"recover": {
+			usage: "recover - recover the changes of a crashed session from the swap file",
+			execute: func(doc *DocStruct, args []string) error {
+				doc.handleEventRecover()
+				return nil
+			},
+		},
+		"reload": {
+			usage: "reload - load the file again, undo restores the text before",
+			execute: func(doc *DocStruct, args []string) error {
+				if doc.scratch {
+					return fmt.Errorf("%s has no file", doc.filename)
+				}
+				doc.handleEventReload()
+				return nil
+			},
+		},
+		"syntax": {
+			usage:   "syntax [NAME|off] - show or set the language of the syntax highlighting",
+			execute: executeSyntax,
+		},
+		"theme": {
+			usage:   "theme [NAME] - show or change the color theme",
+			execute: executeTheme,
+		},
+		"linenumbers": {
+			usage:   "linenumbers on|off|relative - show line numbers left of the text",
+			execute: executeLineNumbers,
+		},
+		"wrap": {
+			usage:   "wrap on|off|words - wrap long lines at the window width or at words",
+			execute: executeWrap,
+		},
+		"tabwidth": {
+			usage:   "tabwidth N - set the number of columns between tab stops",
+			execute: executeTabWidth,
+		},
+		"expandtabs": {
+			usage:   "expandtabs on|off - insert spaces instead of a tab with the tab key",
+			execute: executeExpandTabs,
+		},
+		"finalnewline": {
+			usage:    "finalnewline on|off - end the last line with a line ending",
+			modifies: true,
+			execute:  executeFinalNewline,
+		},
+	}
+}
+
+func (doc *DocStruct) handleEventCommand() {
+	doc.openPrompt(&PromptStruct{
+		label: "command: ",
+		onEnter: func(input string) {
+			if err := doc.executeCommand(input); err != nil {
+				doc.setError("%v", err)
+			}
+		},
+	})
+}
+
+func (doc *DocStruct) executeCommand(input string) error {
+	fields := strings.Fields(input)
+	if len(fields) == 0 {
+		return nil
+	}
+	command, ok := commands[fields[0]]
+	if !ok {
+		return fmt.Errorf("unknown command %q, try help", fields[0])
+	}
+	if command.modifies && doc.readonly {
+		return fmt.Errorf("%s: document is read only", fields[0])
+	}
+	return command.execute(doc, fields[1:])
+}
+
+func executeHelp(doc *DocStruct, args []string) error {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	doc.setStatus("commands: %s", strings.Join(names, ", "))
+	return nil
+}
+
+func parseOnOff(args []string) (bool, error) {
+	if len(args) == 1 {
+		switch args[0] {
+		case "on":
+			return true, nil
+		case "off":
+			return false, nil
+		}
+	}
+	return false, fmt.Errorf("expected on or off")
+}
+
+func executeLineEnding(doc *DocStruct, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: %s", commands["lineending"].usage)
+	}
+	lineEnding, ok := parseLineEnding(args[0])
+	if !ok {
+		return fmt.Errorf("unknown line ending %q", args[0])
+	}
+	doc.format.lineEnding = lineEnding
+	doc.format.mixed = false
+	doc.renderInfoLine()
+	doc.setStatus("line ending set to %s", lineEnding)
+	return nil
+}
+
+func executeBom(doc *DocStruct, args []string) error {
+	on, err := parseOnOff(args)
+	if err != nil {
+		return err
+	}
+	doc.format.bom = on
+	doc.renderInfoLine()
+	return nil
+}
+
+func executeFinalNewline(doc *DocStruct, args []string) error {
+	on, err := parseOnOff(args)
+	if err != nil {
+		return err
+	}
+	doc.format.finalNewline = on
+	doc.renderInfoLine()
+	return nil
+}
+
+func executeEncoding(doc *DocStruct, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: %s", commands["encoding"].usage)
+	}
+	encoding, err := lookupEncoding(args[0])
+	if err != nil {
+		return err
+	}
+	doc.format.encoding = encoding
+	doc.renderInfoLine()
+	doc.setStatus("encoding set to %s", encoding.name)
+	return nil
+}
+
+func executeEarlier(doc *DocStruct, args []string) error {
+	return executeUndoTarget(doc, args, false)
+}
+
+func executeLater(doc *DocStruct, args []string) error {
+	return executeUndoTarget(doc, args, true)
+}
+
+func executeUndoTarget(doc *DocStruct, args []string, forward bool) error {
+	if len(args) != 1 {
+		return errUndoArgument
+	}
+	target, err := undoTarget(&doc.Undo, args[0], forward)
+	if err != nil {
+		return err
+	}
+	doc.gotoUndoState(target)
+	return executeUndoState(doc, nil)
+}
+
+func executeUndoState(doc *DocStruct, args []string) error {
+	ut := &doc.Undo
+	if len(args) == 1 {
+		target, err := strconv.Atoi(args[0])
+		if err != nil || target < 0 || target >= len(ut.Nodes) {
+			return fmt.Errorf("state must be between 0 and %d", len(ut.Nodes)-1)
+		}
+		doc.gotoUndoState(target)
+	}
+	node := ut.Nodes[ut.Current]
+	doc.setStatus("undo state %d of %d, changed %s, %d branches",
+		ut.Current, len(ut.Nodes)-1, node.Time.Format("15:04:05"), len(node.Children))
+	return nil
+}
+
+func executeGrep(doc *DocStruct, args []string) error {
+	if len(args) == 0 {
+		doc.handleEventGrep()
+		return nil
+	}
+	return editor.startGrep(strings.Join(args, " "), false)
+}
+
+func executeSyntax(doc *DocStruct, args []string) error {
+	if len(args) == 0 {
+		names := make([]string, len(syntaxes))
+		for i, syn := range syntaxes {
+			names[i] = syn.name
+		}
+		current := "off"
+		if doc.highlight != nil {
+			current = doc.highlight.syntax.name
+		}
+		doc.setStatus("syntax %s, available: %s", current, strings.Join(names, ", "))
+		return nil
+	}
+	if len(args) != 1 {
+		return fmt.Errorf("usage: %s", commands["syntax"].usage)
+	}
+	if args[0] == "off" {
+		doc.highlight = nil
+		doc.applyIndent(nil)
+	} else {
+		syn := lookupSyntax(args[0])
+		if syn == nil {
+			return fmt.Errorf("unknown syntax %q", args[0])
+		}
+		doc.highlight = newHighlight(syn)
+		doc.applyIndent(syn)
+	}
+	editor.renderWindows()
+	return nil
+}
+
+func executeTheme(doc *DocStruct, args []string) error {
+	if len(args) == 0 {
+		doc.setStatus("theme %s, available: %s", doc.screen.name, strings.Join(themeNames(), ", "))
+		return nil
+	}
+	if len(args) != 1 {
+		return fmt.Errorf("usage: %s", commands["theme"].usage)
+	}
+	if err := editor.screen.setTheme(args[0]); err != nil {
+		return err
+	}
+	editor.renderWindows()
+	return nil
+}
+
+func executeLineNumbers(doc *DocStruct, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: %s", commands["linenumbers"].usage)
+	}
+	lineNumbers, ok := parseLineNumbers(args[0])
+	if !ok {
+		return fmt.Errorf("unknown line numbers mode %q", args[0])
+	}
+	doc.lineNumbers = lineNumbers
+	doc.adjustViewport()
+	doc.renderScreen()
+	return nil
+}
+
+func executeWrap(doc *DocStruct, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: %s", commands["wrap"].usage)
+	}
+	wrap, ok := parseWrap(args[0])
+	if !ok {
+		return fmt.Errorf("unknown wrap mode %q", args[0])
+	}
+	doc.wrap = wrap
+	doc.topRow = 0
+	doc.updateWantX()
+	doc.adjustViewport()
+	doc.renderScreen()
+	return nil
+}
+
+func executeTabWidth(doc *DocStruct, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: %s", commands["tabwidth"].usage)
+	}
+	width, err := strconv.Atoi(args[0])
+	if err != nil || width < 1 || width > 32 {
+		return fmt.Errorf("tab width must be between 1 and 32")
+	}
+	doc.tabWidth = width
+	editor.renderWindows()
+	return nil
+}
+
+func executeExpandTabs(doc *DocStruct, args []string) error {
+	on, err := parseOnOff(args)
+	if err != nil {
+		return err
+	}
+	doc.expandTabs = on
+	return nil
+}
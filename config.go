@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ConfigStruct holds the settings of the config file. The file consists of
+// lines "key = value", empty lines and lines starting with '#' are ignored.
+type ConfigStruct struct {
+	values map[string]string
+}
+
+var config = ConfigStruct{values: map[string]string{}}
+
+func defaultConfigPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "edit", "config")
+}
+
+func loadConfig(path string) (ConfigStruct, error) {
+	c := ConfigStruct{values: map[string]string{}}
+	f, err := os.Open(path)
+	if err != nil {
+		return c, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, found := cut(line, "=")
+		if !found {
+			return c, fmt.Errorf("%s:%d: missing '=' in %q", path, lineNumber, line)
+		}
+		c.values[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+	return c, scanner.Err()
+}
+
+func (c *ConfigStruct) get(key, def string) string {
+	if value, ok := c.values[key]; ok {
+		return value
+	}
+	return def
+}
+
+func (c *ConfigStruct) getInt(key string, def int) int {
+	value, err := strconv.Atoi(c.get(key, ""))
+	if err != nil {
+		return def
+	}
+	return value
+}
+
+func (c *ConfigStruct) getBool(key string, def bool) bool {
+	value, err := strconv.ParseBool(c.get(key, ""))
+	if err != nil {
+		return def
+	}
+	return value
+}
+
+// cut slices s around the first instance of sep (strings.Cut needs go 1.18)
+func cut(s, sep string) (before, after string, found bool) {
+	if i := strings.Index(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
+	}
+	return s, "", false
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"fmt"
+
+	"jostermeier.de/edit/buffer"
+)
+
+const (
+	diffContext  = 3    // unchanged lines shown around a change
+	diffMaxEdits = 1000 // larger differences are shown as replacement of everything
+)
+
+type DiffOpType int
+
+const (
+	diffEqual DiffOpType = iota
+	diffDelete
+	diffInsert
+)
+
+// DiffStruct is a run of lines of the difference between two texts. Equal
+// and deleted lines are the lines of a from row a, equal and inserted lines
+// the lines of b from row b.
+type DiffStruct struct {
+	op    DiffOpType
+	a, b  int
+	count int
+}
+
+func equalLines(a, b LineType) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// diffLines returns the shortest edit script turning a into b (Myers'
+// algorithm). Common lines at the start and the end are skipped first, so
+// the memory used depends on the number of changes only. The lines are
+// compared one by one, lines of a file aren't kept decoded.
+func diffLines(a, b buffer.Text) []DiffStruct {
+	n, m := a.Len(), b.Len()
+	start := 0
+	for start < n && start < m && equalLines(a.Line(start), b.Line(start)) {
+		start++
+	}
+	endA, endB := n, m
+	for endA > start && endB > start && equalLines(a.Line(endA-1), b.Line(endB-1)) {
+		endA--
+		endB--
+	}
+	var script []DiffStruct
+	add := func(op DiffOpType, a, b, count int) {
+		if count == 0 {
+			return
+		}
+		if last := len(script) - 1; last >= 0 && script[last].op == op {
+			script[last].count += count
+			return
+		}
+		script = append(script, DiffStruct{op, a, b, count})
+	}
+	add(diffEqual, 0, 0, start)
+	var changedA, changedB []LineType
+	for y := start; y < endA; y++ {
+		changedA = append(changedA, a.Line(y))
+	}
+	for y := start; y < endB; y++ {
+		changedB = append(changedB, b.Line(y))
+	}
+	for _, d := range diffChanged(changedA, changedB) {
+		add(d.op, start+d.a, start+d.b, d.count)
+	}
+	add(diffEqual, endA, endB, n-endA)
+	return script
+}
+
+// diffChanged returns the edit script of the lines between the common
+// lines at the start and the end, a step for every line
+func diffChanged(a, b []LineType) []DiffStruct {
+	n, m := len(a), len(b)
+	// v[k] is the furthest x on diagonal k = x - y, trace[d] holds v[-d..d]
+	// before step d
+	offset := n + m + 1
+	v := make([]int, 2*offset+1)
+	var trace [][]int
+	found := false
+	for d := 0; d <= n+m && d <= diffMaxEdits && !found; d++ {
+		trace = append(trace, append([]int{}, v[offset-d:offset+d+1]...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1] // down, insert
+			} else {
+				x = v[offset+k-1] + 1 // right, delete
+			}
+			y := x - k
+			for x < n && y < m && equalLines(a[x], b[y]) {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				found = true
+				break
+			}
+		}
+	}
+
+	if !found {
+		return []DiffStruct{{diffDelete, 0, 0, n}, {diffInsert, n, 0, m}}
+	}
+
+	// go back from the end along the path, collecting the script reversed
+	var script []DiffStruct
+	x, y := n, m
+	for d := len(trace) - 1; d > 0; d-- {
+		prev := trace[d]
+		at := func(k int) int { return prev[k+d] }
+		k := x - y
+		prevK := k - 1
+		if k == -d || (k != d && at(k-1) < at(k+1)) {
+			prevK = k + 1
+		}
+		prevX := at(prevK)
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			x--
+			y--
+			script = append(script, DiffStruct{diffEqual, x, y, 1})
+		}
+		if x == prevX {
+			script = append(script, DiffStruct{diffInsert, prevX, prevY, 1})
+		} else {
+			script = append(script, DiffStruct{diffDelete, prevX, prevY, 1})
+		}
+		x, y = prevX, prevY
+	}
+	for x > 0 && y > 0 {
+		x--
+		y--
+		script = append(script, DiffStruct{diffEqual, x, y, 1})
+	}
+	for i, j := 0, len(script)-1; i < j; i, j = i+1, j-1 {
+		script[i], script[j] = script[j], script[i]
+	}
+	return script
+}
+
+// unifiedDiff formats the difference of a and b like diff -u
+func unifiedDiff(nameA, nameB string, a, b buffer.Text) []LineType {
+	script := diffLines(a, b)
+	lines := []LineType{
+		LineType("--- " + nameA),
+		LineType("+++ " + nameB),
+	}
+	for i := 0; i < len(script); {
+		if script[i].op == diffEqual {
+			i++
+			continue
+		}
+		// a hunk reaches until the last change which isn't followed by
+		// more than 2*diffContext unchanged lines, with diffContext
+		// unchanged lines around
+		end := i
+		for j := i; j < len(script); j++ {
+			if script[j].op != diffEqual {
+				end = j + 1
+			} else if script[j].count > 2*diffContext {
+				break
+			}
+		}
+		before, after := 0, 0
+		if i > 0 {
+			before = script[i-1].count
+		}
+		if end < len(script) {
+			after = script[end].count
+		}
+		if before > diffContext {
+			before = diffContext
+		}
+		if after > diffContext {
+			after = diffContext
+		}
+		hunk := append([]DiffStruct{}, script[i:end]...)
+		if before > 0 {
+			d := script[i-1]
+			hunk = append([]DiffStruct{{diffEqual, d.a + d.count - before, d.b + d.count - before, before}}, hunk...)
+		}
+		if after > 0 {
+			d := script[end]
+			hunk = append(hunk, DiffStruct{diffEqual, d.a, d.b, after})
+		}
+
+		countA, countB := 0, 0
+		for _, d := range hunk {
+			if d.op != diffInsert {
+				countA += d.count
+			}
+			if d.op != diffDelete {
+				countB += d.count
+			}
+		}
+		lines = append(lines, LineType(fmt.Sprintf("@@ -%d,%d +%d,%d @@",
+			hunk[0].a+1, countA, hunk[0].b+1, countB)))
+		for _, d := range hunk {
+			for k := 0; k < d.count; k++ {
+				switch d.op {
+				case diffEqual:
+					lines = append(lines, concatenateLines(LineType(" "), a.Line(d.a+k)))
+				case diffDelete:
+					lines = append(lines, concatenateLines(LineType("-"), a.Line(d.a+k)))
+				case diffInsert:
+					lines = append(lines, concatenateLines(LineType("+"), b.Line(d.b+k)))
+				}
+			}
+		}
+		i = end
+	}
+	return lines
+}
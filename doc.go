@@ -1,7 +1,12 @@
 package main
 
 import (
+	"crypto/sha256"
+	"os"
+	"strings"
+
 	"github.com/gdamore/tcell/v2"
+	"jostermeier.de/edit/buffer"
 )
 
 type CursorStruct struct {
@@ -10,12 +15,10 @@ type CursorStruct struct {
 
 type ScreenStruct struct {
 	tcell.Screen
-	defaultStyle   tcell.Style
-	selectionStyle tcell.Style
-	infoStyle      tcell.Style
+	*ThemeStruct
 }
 
-type LineType []rune
+type LineType = buffer.Line
 
 func concatenateLines(lines ...LineType) LineType {
 	line := LineType{}
@@ -25,85 +28,106 @@ func concatenateLines(lines ...LineType) LineType {
 	return line
 }
 
-type LineSlice []LineType
-
 type selectionStruct struct {
 	begin, end xyStruct
 }
 
 var emptySelection selectionStruct
 
+// BufferStruct is the text of a file with its undo history, it is shared by
+// all views of the file
+type BufferStruct struct {
+	*buffer.Buffer
+	filename     string
+	readonly     bool
+	encodingName string // encoding selected by the user, empty to detect
+	format       FileFormatStruct
+	savedFormat  FileFormatStruct  // format of the file when loaded or saved
+	savedHash    [sha256.Size]byte // hash of the file content when loaded or saved
+	diskInfo     os.FileInfo       // of the file when loaded or saved, to notice changes
+	scratch      bool              // not backed by a file
+	grep         *GrepStruct       // set for the results of a grep
+	swap         SwapStruct
+	highlight    *HighlightStruct // nil if not highlighted
+	tabWidth     int
+	expandTabs   bool         // the tab key inserts spaces
+	redraw       redrawStruct // changes not shown in the windows yet
+}
+
+// newBuffer returns a buffer with an empty line
+func newBuffer(filename string) *BufferStruct {
+	buf := &BufferStruct{
+		Buffer:      buffer.New(buffer.NewPieceText(LineType{})),
+		filename:    filename,
+		format:      defaultFileFormat,
+		savedFormat: defaultFileFormat,
+	}
+	buf.Subscribe(buf.changed)
+	return buf
+}
+
+// changed is called for every change of the text, it keeps the highlighting
+// up to date and collects the lines to redraw and the changes for the swap
+// file
+func (buf *BufferStruct) changed(c buffer.Change) {
+	switch c.Kind {
+	case buffer.LineChanged:
+		buf.highlight.changed(c.Y)
+		buf.redraw.line(c.Y)
+	case buffer.LineInserted:
+		buf.highlight.inserted(c.Y)
+		buf.redraw.all = true
+	case buffer.LineDeleted:
+		buf.highlight.deleted(c.Y)
+		buf.redraw.all = true
+	case buffer.TextReplaced:
+		buf.highlight.invalidate()
+		buf.redraw.all = true
+	}
+	buf.swap.changed(c, buf.Text())
+}
+
+// DocStruct is a view of a buffer, every window showing the buffer has its
+// own cursor, viewport and selection
 type DocStruct struct {
-	filename       string
-	text           LineSlice
+	*BufferStruct
 	screen         ScreenStruct
 	absolutCursor  CursorStruct
 	previousCursor CursorStruct
 	viewport       xyStruct
-	undoStack      UndoStackStruct
 	selection      selectionStruct
+	prompt         *PromptStruct
+	lastPaste      *PasteStruct
+	search         SearchStruct
+	pasteBuffer    *strings.Builder // collects a bracketed paste, nil if not pasting
+	statusMessage  string
+	statusIsError  bool
+	lineNumbers    LineNumbersType
+	gutter         GutterStruct // as rendered last
+	wrap           WrapType
+	topRow         int // in wrap mode the row of line viewport.y at the top
 }
 
-func (doc *DocStruct) updateLine(ui *UndoItemStruct, row int, line LineType) {
-	if ui != nil {
-		// create action for undo
-		action := ActionStruct{
-			row:     row,
-			delete:  false,
-			insert:  false,
-			update:  true,
-			line:    doc.text[row],
-			cursorX: doc.absolutCursor.x,
-		}
-		ui.actionSlice = append(ui.actionSlice, action)
-	}
-	// do actual update
-	doc.text[row] = line
+// updateLine, insertLine and deleteLine change the buffer and record the
+// cursor, undo puts it back there
+func (doc *DocStruct) updateLine(ui *buffer.Edit, row int, line LineType) {
+	doc.recordCursor(ui)
+	doc.SetLine(ui, row, line)
 }
 
-func (doc *DocStruct) insertLine(ui *UndoItemStruct, row int, line LineType) {
-	// insert line
-	if row >= len(doc.text) {
-		doc.text = append(doc.text, LineType{})
-	} else {
-		doc.text = append(doc.text[:row+1], doc.text[row:]...)
-	}
-	if ui != nil {
-		// create actionItem for Undo
-		action := ActionStruct{
-			row:     row,
-			delete:  false,
-			insert:  true,
-			update:  true,
-			line:    doc.text[row],
-			cursorX: doc.absolutCursor.x,
-		}
-		ui.actionSlice = append(ui.actionSlice, action)
-	}
-	// update line
-	doc.updateLine(ui, row, line)
+func (doc *DocStruct) insertLine(ui *buffer.Edit, row int, line LineType) {
+	doc.recordCursor(ui)
+	doc.InsertLine(ui, row, line)
 }
 
-func (doc *DocStruct) deleteLine(ui *UndoItemStruct, row int) {
-	if row >= len(doc.text) {
-		return
-	}
+func (doc *DocStruct) deleteLine(ui *buffer.Edit, row int) {
+	doc.recordCursor(ui)
+	doc.DeleteLine(ui, row)
+}
+
+func (doc *DocStruct) recordCursor(ui *buffer.Edit) {
 	if ui != nil {
-		// create actionItem for Undo
-		action := ActionStruct{
-			row:     row,
-			delete:  true,
-			insert:  false,
-			update:  true,
-			line:    doc.text[row],
-			cursorX: doc.absolutCursor.x,
-		}
-		ui.actionSlice = append(ui.actionSlice, action)
-	}
-	if row+1 < len(doc.text) {
-		doc.text = append(doc.text[:row], doc.text[row+1:]...)
-	} else {
-		doc.text = doc.text[:row]
+		ui.CursorX = doc.absolutCursor.x
 	}
 }
 
@@ -153,139 +177,198 @@ func (doc *DocStruct) updateSelection(set bool) {
 	doc.renderScreen()
 }
 
-func (doc *DocStruct) deleteSelection(ui *UndoItemStruct) {
+// selectionRange returns the selection as half-open range [from, to). The
+// selection end is inclusive, an end behind the last character of a line
+// includes the line break.
+func (doc *DocStruct) selectionRange() (from, to xyStruct) {
+	from = doc.selection.begin
+	to = xyStruct{x: doc.selection.end.x + 1, y: doc.selection.end.y}
+	if to.y < doc.Len() && to.x > len(doc.Line(to.y)) {
+		to = xyStruct{x: 0, y: to.y + 1}
+	} else if to.y < doc.Len() && to.x > 0 {
+		// the whole cluster of the last selected rune
+		to.x = nextCluster(doc.Line(to.y), to.x-1)
+	}
+	return doc.clampPosition(from), doc.clampPosition(to)
+}
+
+// clampPosition moves a position outside of the text to the nearest position inside
+func (doc *DocStruct) clampPosition(xy xyStruct) xyStruct {
+	return xyOf(doc.Clamp(xy.position()))
+}
+
+// textInRange returns a copy of the text in the half-open range [from, to)
+func (doc *DocStruct) textInRange(from, to xyStruct) []LineType {
+	return doc.TextInRange(buffer.Range{From: from.position(), To: to.position()})
+}
+
+// deleteRange deletes the text in the half-open range [from, to)
+func (doc *DocStruct) deleteRange(ui *buffer.Edit, from, to xyStruct) {
+	doc.recordCursor(ui)
+	doc.Delete(ui, buffer.Range{From: from.position(), To: to.position()})
+}
+
+// insertText inserts lines at position xy and returns the position behind
+// the inserted text
+func (doc *DocStruct) insertText(ui *buffer.Edit, xy xyStruct, lines []LineType) xyStruct {
+	doc.recordCursor(ui)
+	return xyOf(doc.Insert(ui, xy.position(), lines))
+}
+
+func (doc *DocStruct) deleteSelection(ui *buffer.Edit) {
 	if doc.selection == emptySelection {
 		return
 	}
-	if doc.selection.begin.y == doc.selection.end.y {
-		// selection in single line
-		y := doc.selection.begin.y
-		leftPartOfLine := doc.text[y][:doc.selection.begin.x]
-		rightPartOfLine := doc.text[y][doc.selection.end.x:]
-		doc.updateLine(ui, y, concatenateLines(leftPartOfLine, rightPartOfLine))
-	}
+	from, to := doc.selectionRange()
+	doc.deleteRange(ui, from, to)
 
-	doc.absolutCursor.x = doc.selection.begin.x
-	doc.absolutCursor.wantX = doc.absolutCursor.x
-	doc.absolutCursor.y = doc.selection.begin.y
-	doc.alignCursorX()
+	doc.absolutCursor.x = from.x
+	doc.absolutCursor.y = from.y
+	doc.updateWantX()
 
 	doc.selection = emptySelection
-	// doc.updateLine(ui, y, concatenateLines(doc.text[y], doc.text[y+1]))
-	// doc.deleteLine(ui, y+1)
 }
 
 func (doc *DocStruct) handleEventInsertCharacter(r rune) {
-	undoItem := newUndoItem()
+	// typing a word is one undo step
+	undoItem := buffer.Edit{Mergeable: true}
 	x := doc.absolutCursor.x
 	y := doc.absolutCursor.y
 
 	newRune := LineType{r}
-	doc.updateLine(&undoItem, y, concatenateLines(doc.text[y][:x], newRune, doc.text[y][x:]))
-	doc.undoStack.push(undoItem)
+	doc.updateLine(&undoItem, y, concatenateLines(doc.Line(y)[:x], newRune, doc.Line(y)[x:]))
+	doc.Undo.Push(undoItem)
 
 	doc.absolutCursor.x++
-	doc.absolutCursor.wantX = doc.absolutCursor.x
+	doc.updateWantX()
 	doc.adjustViewport()
-	doc.renderLine(y)
 }
 
 func (doc *DocStruct) handleEventBackspace() {
-	undoItem := newUndoItem()
+	if doc.selection != emptySelection {
+		doc.handleEventDelete()
+		return
+	}
+	undoItem := buffer.Edit{}
 	x := doc.absolutCursor.x
 	y := doc.absolutCursor.y
 	if x <= 0 {
 		// backspace when cursor is on first position of line
 		if y > 0 {
-			doc.absolutCursor.x = len(doc.text[y-1])
-			doc.absolutCursor.wantX = doc.absolutCursor.x
-			doc.updateLine(&undoItem, y-1, concatenateLines(doc.text[y-1], doc.text[y]))
+			doc.absolutCursor.x = len(doc.Line(y - 1))
+			doc.updateLine(&undoItem, y-1, concatenateLines(doc.Line(y-1), doc.Line(y)))
 			doc.deleteLine(&undoItem, y)
-			doc.undoStack.push(undoItem)
+			doc.Undo.Push(undoItem)
 
 			doc.absolutCursor.y--
+			doc.updateWantX()
 			doc.adjustViewport()
-			doc.renderScreen()
 		}
 	} else {
-		doc.updateLine(&undoItem, y, concatenateLines(doc.text[y][:x-1], doc.text[y][x:]))
-		doc.undoStack.push(undoItem)
-		doc.renderLine(y)
+		prev := prevCluster(doc.Line(y), x)
+		doc.updateLine(&undoItem, y, concatenateLines(doc.Line(y)[:prev], doc.Line(y)[x:]))
+		doc.Undo.Push(undoItem)
 
-		doc.absolutCursor.x--
-		doc.absolutCursor.wantX = doc.absolutCursor.x
-		doc.alignCursorX()
+		doc.absolutCursor.x = prev
+		doc.updateWantX()
 	}
 }
 
 func (doc *DocStruct) handleEventDelete() {
-	undoItem := newUndoItem()
+	undoItem := buffer.Edit{}
 
 	if doc.selection != emptySelection {
-		// dek whole selection
+		// delete whole selection
 		doc.deleteSelection(&undoItem)
-		doc.undoStack.push(undoItem)
-		doc.renderScreen()
+		doc.Undo.Push(undoItem)
+		doc.adjustViewport()
+		return
 	}
 
 	x := doc.absolutCursor.x
 	y := doc.absolutCursor.y
-	l := len(doc.text)
-	if x == len(doc.text[y]) {
+	l := doc.Len()
+	if x == len(doc.Line(y)) {
 		// pressing delete when cursor is at end of line
 		if y+1 < l {
-			doc.updateLine(&undoItem, y, concatenateLines(doc.text[y], doc.text[y+1]))
+			doc.updateLine(&undoItem, y, concatenateLines(doc.Line(y), doc.Line(y+1)))
 			doc.deleteLine(&undoItem, y+1)
-			doc.undoStack.push(undoItem)
+			doc.Undo.Push(undoItem)
 
 			doc.adjustViewport()
-			doc.renderScreen()
 		}
 	} else {
 		// pressing delete somewhere in the line
-		doc.updateLine(&undoItem, y, concatenateLines(doc.text[y][:x], doc.text[y][x+1:]))
-		doc.undoStack.push(undoItem)
+		doc.updateLine(&undoItem, y, concatenateLines(doc.Line(y)[:x], doc.Line(y)[nextCluster(doc.Line(y), x):]))
+		doc.Undo.Push(undoItem)
 
-		doc.renderLine(y)
-		doc.alignCursorX()
+		doc.updateWantX()
 	}
 }
 
 func (doc *DocStruct) handleEventEnter() {
-	undoItem := newUndoItem()
+	undoItem := buffer.Edit{}
 	x := doc.absolutCursor.x
 	y := doc.absolutCursor.y
 	newLine := LineType{}
-	if x != len(doc.text[y]) {
+	if x != len(doc.Line(y)) {
 		// split line if cursor is not at the end
-		newLine = doc.text[y][x:]
-		doc.updateLine(&undoItem, y, doc.text[y][:x])
+		newLine = doc.Line(y)[x:]
+		doc.updateLine(&undoItem, y, doc.Line(y)[:x])
 	}
 	doc.insertLine(&undoItem, y+1, newLine)
-	doc.undoStack.push(undoItem)
+	doc.Undo.Push(undoItem)
 	doc.absolutCursor.x = 0
 	doc.absolutCursor.wantX = 0
 	doc.absolutCursor.y++
 	doc.adjustViewport()
-	doc.renderScreen()
 }
 
+// handleEventInsertTab inserts a tab, or spaces up to the next tab stop
 func (doc *DocStruct) handleEventInsertTab() {
-	undoItem := newUndoItem()
-	const fakeTab string = "    "
+	undoItem := buffer.Edit{}
 	x := doc.absolutCursor.x
 	y := doc.absolutCursor.y
-	doc.updateLine(&undoItem, y, concatenateLines(doc.text[y][:x], LineType(fakeTab), doc.text[y][x:]))
-	doc.undoStack.push(undoItem)
+	tab := LineType{'\t'}
+	if doc.expandTabs {
+		col := doc.column(doc.Line(y), x)
+		tab = LineType(strings.Repeat(" ", doc.tabSize()-col%doc.tabSize()))
+	}
+	doc.updateLine(&undoItem, y, concatenateLines(doc.Line(y)[:x], tab, doc.Line(y)[x:]))
+	doc.Undo.Push(undoItem)
 
-	doc.renderLine(y)
-	doc.absolutCursor.x += len(fakeTab)
-	doc.absolutCursor.wantX = doc.absolutCursor.x
+	doc.absolutCursor.x += len(tab)
+	doc.updateWantX()
+	doc.adjustViewport()
 }
 
 func (doc *DocStruct) handleKeyEvent(event *tcell.EventKey) {
+	// only a paste directly before can be cycled
+	lastPaste := doc.lastPaste
+	doc.lastPaste = nil
+	// search matches are highlighted until another key is pressed
+	if doc.search.highlight && event.Key() != tcell.KeyF3 {
+		doc.search.highlight = false
+		doc.renderScreen()
+	}
+
 	if doc.handleKeyEventCursor(event) {
 		return
+	} else if event.Key() == tcell.KeyCtrlC {
+		doc.handleEventCopy()
+	} else if event.Key() == tcell.KeyCtrlE {
+		doc.handleEventCommand()
+	} else if event.Key() == tcell.KeyCtrlF {
+		doc.handleEventSearch()
+	} else if event.Key() == tcell.KeyF3 {
+		doc.handleEventSearchNext(event.Modifiers()&tcell.ModShift == 0)
+	} else if event.Key() == tcell.KeyEnter && doc.grep != nil {
+		doc.handleEventGrepOpen()
+	} else if doc.readonly {
+		// no editing in read only documents
+		doc.screen.Beep()
+	} else if event.Key() == tcell.KeyRune && event.Modifiers()&tcell.ModAlt != 0 && event.Rune() == 'v' {
+		doc.handleEventPasteCycle(lastPaste)
 	} else if event.Key() == tcell.KeyRune {
 		doc.handleEventInsertCharacter(event.Rune())
 	} else if event.Key() == tcell.KeyBackspace || event.Key() == tcell.KeyBackspace2 {
@@ -294,12 +377,19 @@ func (doc *DocStruct) handleKeyEvent(event *tcell.EventKey) {
 		doc.handleEventDelete()
 	} else if event.Key() == tcell.KeyEnter {
 		doc.handleEventEnter()
-	} else if event.Key() == tcell.KeyCtrlZ || event.Key() == tcell.KeyCtrlR {
+	} else if event.Key() == tcell.KeyCtrlZ {
 		// Undo
 		doc.handleEventUndo()
+	} else if event.Key() == tcell.KeyCtrlR || event.Key() == tcell.KeyCtrlY {
+		// Redo
+		doc.handleEventRedo()
 	} else if event.Key() == tcell.KeyCtrlS {
 		// save
-		doc.handleEventSave()
+		doc.handleEventSaveWithStatus()
+	} else if event.Key() == tcell.KeyCtrlX {
+		doc.handleEventCut()
+	} else if event.Key() == tcell.KeyCtrlV {
+		doc.handleEventPaste()
 	} else if event.Key() == tcell.KeyTab {
 		doc.handleEventInsertTab()
 	}
@@ -2,9 +2,12 @@ package main
 
 import (
 	"errors"
+	"flag"
 	"fmt"
-	"log"
+	"io"
 	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/gdamore/tcell/v2"
 	"github.com/gdamore/tcell/v2/encoding"
@@ -23,105 +26,169 @@ func fileExists(path string) (bool, error) {
 
 func main() {
 	// handle parameters
-	/*
-		flag.Parse()
-		if flag.NArg() != 1 {
-			fmt.Printf("Missing parameter <filename>\n")
-			fmt.Printf("use: edit <filename>\n")
+	options, err := parseArgs(os.Args[1:], os.Stderr)
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			os.Exit(0)
+		}
+		os.Exit(2)
+	}
+
+	// load config
+	configPath := options.config
+	if configPath == "" {
+		configPath = defaultConfigPath()
+	}
+	if configPath != "" {
+		config, err = loadConfig(configPath)
+		if err != nil && (options.config != "" || !errors.Is(err, os.ErrNotExist)) {
+			fmt.Fprintf(os.Stderr, "edit: %v\n", err)
 			os.Exit(1)
 		}
-		args := flag.Args()
-	*/
-	fmt.Println("edit started") // ToDo remove!
-	args := []string{"test.txt"}
+	}
+	var syntaxDir string
+	if configPath != "" {
+		syntaxDir = filepath.Join(filepath.Dir(configPath), "syntax")
+		themeDir = filepath.Join(filepath.Dir(configPath), "themes")
+	}
+	syntaxErr := loadSyntaxes(syntaxDir)
+	if options.encoding == "" {
+		options.encoding = config.get("encoding", "")
+	}
+	if options.encoding != "" {
+		if _, err := lookupEncoding(options.encoding); err != nil {
+			fmt.Fprintf(os.Stderr, "edit: %v\n", err)
+			os.Exit(2)
+		}
+	}
 
 	// init globals
+	killRing.size = config.getInt("killring_size", killRing.size)
 	emptySelection = selectionStruct{
 		begin: xyStruct{x: -1, y: -1},
 		end:   xyStruct{x: -1, y: -1},
 	}
 
-	// init doc object
-	doc := DocStruct{
-		filename:       args[0],
-		text:           []LineType{},
-		screen:         ScreenStruct{},
-		absolutCursor:  CursorStruct{x: 20, y: 7, wantX: 0},
-		previousCursor: CursorStruct{x: 0, y: 0, wantX: 0},
-		viewport:       xyStruct{x: 0, y: 0},
-		undoStack: UndoStackStruct{
-			undoSlice: []UndoItemStruct{},
-			top:       0,
-		},
-		selection: selectionStruct{
-			begin: xyStruct{x: -1, y: -1},
-			end:   xyStruct{x: -1, y: -1},
-		},
-	}
-
 	// Initialize tcell
 	encoding.Register()
-	screen, err := tcell.NewScreen()
+	screen, terminal, err := newScreen()
 	if err != nil {
-		fmt.Println("error creating screen")
-		log.Fatalf("%+v\n", err)
+		fmt.Fprintf(os.Stderr, "edit: error creating screen: %v\n", err)
+		os.Exit(1)
 	}
-	doc.screen = ScreenStruct{
-		Screen:       screen,
-		defaultStyle: tcell.StyleDefault.Background(tcell.ColorReset).Foreground(tcell.ColorReset),
-		infoStyle:    tcell.StyleDefault.Background(tcell.ColorReset).Foreground(tcell.ColorRed),
+	// the theme is set when the colors of the terminal are known
+	editor.screen = ScreenStruct{Screen: screen, ThemeStruct: &ThemeStruct{}}
+
+	err = screen.Init()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "edit: error initializing screen: %v\n", err)
+		os.Exit(1)
 	}
-	doc.screen.selectionStyle = doc.screen.defaultStyle.Reverse(true)
 
-	err = doc.screen.Init()
+	// load document, the screen must be ready to show its errors
+	doc, err := loadDoc(options.filename, options.encoding, options.readonly)
 	if err != nil {
-		fmt.Println("error initializing screen")
-		log.Fatalf("%+v\n", err)
+		screen.Fini()
+		fmt.Fprintf(os.Stderr, "edit: %v\n", err)
+		os.Exit(1)
 	}
+	editor.addDoc(doc)
 
-	// load document
-	err = doc.handleEventLoad()
+	// connect to the system clipboard
+	systemClipboard, err = selectClipboard(terminal)
 	if err != nil {
-		if errors.Is(err, os.ErrNotExist) {
-			doc.text = append(doc.text, LineType{})
-		} else {
-			log.Fatalf("%+v\n", err)
-		}
+		doc.setError("%v", err)
+	}
+	if syntaxErr != nil {
+		doc.setError("syntax: %v", syntaxErr)
 	}
 
 	// init screen
-	doc.screen.SetStyle(doc.screen.defaultStyle)
+	if err := editor.screen.setTheme(config.get("theme", "default")); err != nil {
+		editor.screen.setTheme("default")
+		doc.setError("theme: %v", err)
+	}
+	screen.EnablePaste()
+	editor.initWindows()
+	editor.show(doc)
+	if doc.swap.stale != nil {
+		doc.handleEventRecover()
+	}
+	startSwapTimer(screen)
+	startWatcher(screen)
+	if terminal != nil {
+		// save the title of the terminal window, it is restored on quit
+		io.WriteString(terminal, "\x1b[22;2t")
+		editor.terminal = terminal
+		editor.updateTitle()
+	}
+	doc.gotoPosition(options.line, options.column)
 	doc.showCursor()
 
 	// Event loop
 	for {
 		// Update screen
-		doc.screen.Show()
+		screen.Show()
 
 		// handle event
-		event := doc.screen.PollEvent()
+		doc := editor.doc()
+		event := screen.PollEvent()
 		switch event := event.(type) {
+		case *tcell.EventInterrupt:
+			// work done in other goroutines is handed over to the event loop
+			if f, ok := event.Data().(func()); ok {
+				f()
+				editor.renderChanges()
+				editor.doc().showCursor()
+			}
+
 		case *tcell.EventResize:
-			doc.renderScreen()
-			doc.screen.Sync()
+			editor.layout()
+			for _, w := range editor.root.leaves() {
+				w.doc.adjustViewport()
+			}
+			editor.renderWindows()
+			screen.Sync()
+
+		case *tcell.EventPaste:
+			if event.Start() {
+				doc.pasteBuffer = &strings.Builder{}
+			} else if doc.pasteBuffer != nil {
+				text := doc.pasteBuffer.String()
+				doc.pasteBuffer = nil
+				doc.handleEventPasteText(text)
+				editor.renderChanges()
+				editor.updateTitle()
+				doc.showCursor()
+			}
 
 		case *tcell.EventKey:
-			if event.Key() == tcell.KeyEscape || event.Key() == tcell.KeyCtrlC {
+			if doc.pasteBuffer != nil {
+				doc.bufferPasteKey(event)
+				continue
+			}
+			doc.clearStatus()
+			if doc.prompt != nil {
+				doc.handlePromptKeyEvent(event)
+			} else if event.Key() == tcell.KeyEscape || event.Key() == tcell.KeyCtrlQ {
 				// exit
-				doc.screen.Fini()
-				os.Exit(0)
+				editor.handleEventQuit()
 			} else if event.Key() == tcell.KeyCtrlL {
 				// sync
-				doc.screen.Sync()
-				doc.renderScreen()
+				screen.Sync()
+				editor.renderWindows()
 			} else if event.Key() == tcell.KeyCtrlA {
 				doc.renderScreen()
 				doc.screen.Beep()
+			} else if editor.handleKeyEvent(event) {
+				// keys of the document list
 			} else {
 				// handle key events
 				doc.handleKeyEvent(event)
 			}
-			doc.showCursor()
+			editor.renderChanges()
+			editor.updateTitle()
+			editor.doc().showCursor()
 		}
 	}
 }
@@ -1,6 +1,12 @@
 package main
 
-import "testing"
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/gdamore/tcell/v2"
+)
 
 func TestXyGreaterLess(t *testing.T) {
 	xy1 := xyStruct{x: 10, y: 2}
@@ -154,3 +160,482 @@ func TestSelectionIn(t *testing.T) {
 		t.Fatalf("Error")
 	}
 }
+
+func TestParseArgs(t *testing.T) {
+	var output strings.Builder
+	options, err := parseArgs([]string{"-readonly", "+12:5", "file.txt"}, &output)
+	if err != nil {
+		t.Fatalf("Error %v", err)
+	}
+	if options.filename != "file.txt" || !options.readonly {
+		t.Fatalf("Error")
+	}
+	if options.line != 12 || options.column != 5 {
+		t.Fatalf("Error")
+	}
+
+	options, err = parseArgs([]string{"file.txt", "+3"}, &output)
+	if err != nil {
+		t.Fatalf("Error %v", err)
+	}
+	if options.line != 3 || options.column != 0 {
+		t.Fatalf("Error")
+	}
+
+	_, err = parseArgs([]string{}, &output)
+	if err == nil {
+		t.Fatalf("Error")
+	}
+	_, err = parseArgs([]string{"a.txt", "b.txt"}, &output)
+	if err == nil {
+		t.Fatalf("Error")
+	}
+}
+
+func TestDeleteSelection(t *testing.T) {
+	doc := newTestDoc(t, "abc", "def", "ghi")
+	// select "c\nde", end is inclusive
+	doc.selection = selectionStruct{begin: xyStruct{x: 2, y: 0}, end: xyStruct{x: 1, y: 1}}
+	doc.handleEventDelete()
+	if docText(doc) != "abf\nghi" {
+		t.Fatalf("Error %q", docText(doc))
+	}
+	if doc.absolutCursor.x != 2 || doc.absolutCursor.y != 0 {
+		t.Fatalf("Error %v", doc.absolutCursor)
+	}
+	// one undo restores everything
+	doc.handleEventUndo()
+	if docText(doc) != "abc\ndef\nghi" {
+		t.Fatalf("Error %q", docText(doc))
+	}
+
+	// selection ending behind the last character includes the line break
+	doc.selection = selectionStruct{begin: xyStruct{x: 0, y: 1}, end: xyStruct{x: 3, y: 1}}
+	doc.handleEventDelete()
+	if docText(doc) != "abc\nghi" {
+		t.Fatalf("Error %q", docText(doc))
+	}
+}
+
+func TestCutPaste(t *testing.T) {
+	killRing = KillRingStruct{size: 2}
+	doc := newTestDoc(t, "one two", "three")
+	doc.selection = selectionStruct{begin: xyStruct{x: 0, y: 0}, end: xyStruct{x: 2, y: 0}}
+	doc.handleEventCopy()
+	doc.selection = selectionStruct{begin: xyStruct{x: 4, y: 0}, end: xyStruct{x: 1, y: 1}}
+	doc.handleEventCut()
+	if docText(doc) != "one ree" {
+		t.Fatalf("Error %q", docText(doc))
+	}
+	// the changed lines are drawn by renderChanges after the event
+	screen := doc.screen.Screen.(tcell.SimulationScreen)
+	doc.renderChanges()
+	for row, want := range []string{"one ree", "       "} {
+		got := ""
+		for x := 0; x < len(want); x++ {
+			r, _, _, _ := screen.GetContent(x, row)
+			got += string(r)
+		}
+		if got != want {
+			t.Errorf("row %d is %q after the cut", row, got)
+		}
+	}
+	doc.redraw = redrawStruct{}
+	doc.handleEventPaste()
+	if docText(doc) != "one two\nthree" {
+		t.Fatalf("Error %q", docText(doc))
+	}
+	// replace the pasted text with the older entry
+	doc.handleEventPasteCycle(doc.lastPaste)
+	if docText(doc) != "one oneree" {
+		t.Fatalf("Error %q", docText(doc))
+	}
+	doc.handleEventUndo()
+	if docText(doc) != "one two\nthree" {
+		t.Fatalf("Error %q", docText(doc))
+	}
+
+	// a kill ring size below 1 still keeps the last entry
+	killRing = KillRingStruct{size: -1}
+	doc.selection = selectionStruct{begin: xyStruct{x: 0, y: 0}, end: xyStruct{x: 2, y: 0}}
+	doc.handleEventCopy()
+	doc.handleEventCopy()
+	if lines, ok := killRing.get(0); !ok || len(killRing.entries) != 1 || string(lines[0]) != "one" {
+		t.Fatalf("Error %d", len(killRing.entries))
+	}
+
+	// a paste after typing is undone on its own
+	doc = newTestDoc(t, "abc")
+	doc.selection = selectionStruct{begin: xyStruct{x: 0, y: 0}, end: xyStruct{x: 0, y: 0}}
+	doc.handleEventCopy()
+	doc.selection = emptySelection
+	doc.absolutCursor.x = 3
+	typeString(doc, "X")
+	doc.handleEventPaste()
+	if docText(doc) != "abcXa" {
+		t.Fatalf("Error %q", docText(doc))
+	}
+	doc.handleEventUndo()
+	if docText(doc) != "abcX" {
+		t.Fatalf("Error %q", docText(doc))
+	}
+}
+
+func TestFuzzyMatch(t *testing.T) {
+	if _, ok := fuzzyMatch("edit/grep.go", "edg"); !ok {
+		t.Errorf("edg should match edit/grep.go")
+	}
+	if _, ok := fuzzyMatch("edit/grep.go", "gde"); ok {
+		t.Errorf("gde shouldn't match edit/grep.go")
+	}
+	compact, _ := fuzzyMatch("undo.go", "undo")
+	spread, _ := fuzzyMatch("u_n_d_o.go", "undo")
+	if compact >= spread {
+		t.Errorf("compact match should score better: %d >= %d", compact, spread)
+	}
+}
+
+func TestCloseDoc(t *testing.T) {
+	a := newTestDoc(t, "a")
+	b := newTestDoc(t, "b")
+	c := newTestDoc(t, "c")
+	editor = EditorStruct{screen: a.screen, docs: []*DocStruct{a, b, c}}
+	editor.initWindows()
+	editor.show(c)
+	editor.show(a)
+	typeString(a, "x")
+	if !a.modified() {
+		t.Errorf("edited text should be modified")
+	}
+	a.handleEventUndo()
+	if a.modified() {
+		t.Errorf("text undone to the saved state shouldn't be modified")
+	}
+	editor.closeDoc(a)
+	if editor.doc() != c || len(editor.docs) != 2 {
+		t.Errorf("closing should show the previous document")
+	}
+	editor.handleEventCycleDoc(1)
+	if editor.doc() != b {
+		t.Errorf("cycling should wrap around")
+	}
+}
+
+func TestSplitWindow(t *testing.T) {
+	doc := newTestDoc(t, "one", "two", "three")
+	editor = EditorStruct{screen: doc.screen, docs: []*DocStruct{doc}}
+	editor.initWindows()
+	editor.show(doc)
+
+	editor.handleEventSplitWindow(true)
+	top := editor.root.children[0].doc
+	view := editor.doc()
+	if view == top || view.BufferStruct != top.BufferStruct {
+		t.Fatalf("split should show another view of the same buffer")
+	}
+	if w, h := view.screen.Size(); w != 40 || h != 25 {
+		t.Errorf("right window size %d,%d", w, h)
+	}
+
+	// edit in the new window, the other window keeps its cursor
+	top.absolutCursor = CursorStruct{x: 2, y: 2, wantX: 2}
+	view.absolutCursor = CursorStruct{}
+	typeString(view, "new ")
+	editor.renderChanges()
+	if string(top.Line(0)) != "new one" || top.absolutCursor.y != 2 {
+		t.Errorf("got %q, cursor %v", top.Line(0), top.absolutCursor)
+	}
+	view.renderScreen()
+	if r, _, _, _ := doc.screen.Screen.(*RegionStruct).Screen.GetContent(40, 1); r != 't' {
+		t.Errorf("right window not rendered at column 40: %q", r)
+	}
+
+	// lines deleted in one window are clamped in the other
+	for view.Len() > 1 {
+		view.DeleteLine(nil, 1)
+	}
+	editor.renderChanges()
+	if top.absolutCursor.y != 0 {
+		t.Errorf("cursor not clamped: %v", top.absolutCursor)
+	}
+
+	editor.handleEventFocusWindow(-1, 0)
+	if editor.doc() != top {
+		t.Errorf("focus should move to the left window")
+	}
+	editor.handleEventCloseWindow()
+	if editor.root.doc != view || len(editor.root.leaves()) != 1 {
+		t.Errorf("closing should leave the right window")
+	}
+	if w, _ := view.screen.Size(); w != 80 {
+		t.Errorf("remaining window should use the whole width, got %d", w)
+	}
+}
+
+func TestLineNumbers(t *testing.T) {
+	lines := make([]string, 998)
+	for i := range lines {
+		lines[i] = "line"
+	}
+	doc := newTestDoc(t, lines...)
+	screen := doc.screen.Screen.(tcell.SimulationScreen)
+	rowText := func(row, width int) string {
+		s := ""
+		for x := 0; x < width; x++ {
+			r, _, _, _ := screen.GetContent(x, row)
+			s += string(r)
+		}
+		return s
+	}
+
+	// typing in a scrolled document renders the line of the cursor
+	doc.lineNumbers = LineNumbersAbsolute
+	doc.absolutCursor = CursorStruct{y: 990}
+	doc.adjustViewport()
+	typeString(doc, "x")
+	if got := rowText(990-doc.viewport.y, 9); got != "991 xline" {
+		t.Errorf("cursor row is %q", got)
+	}
+	doc.showCursor()
+	if x, y, _ := screen.GetCursor(); x != 5 || y != 990-doc.viewport.y {
+		t.Errorf("cursor shown at %d,%d", x, y)
+	}
+
+	// the gutter grows with the document
+	typeString(doc, "\n\n")
+	doc.showCursor()
+	if got := rowText(992-doc.viewport.y, 10); got != " 993 line " {
+		t.Errorf("cursor row is %q after the gutter grew", got)
+	}
+
+	doc.lineNumbers = LineNumbersRelative
+	doc.showCursor()
+	if got := rowText(990-doc.viewport.y, 5); got != "   2 " {
+		t.Errorf("relative number is %q", got)
+	}
+}
+
+func TestTabs(t *testing.T) {
+	doc := newTestDoc(t, "\tx\ty", "abcdefgh")
+	doc.tabWidth = 4
+	line := doc.Line(0)
+	for _, test := range []struct{ x, col int }{{0, 0}, {1, 4}, {2, 5}, {3, 8}, {4, 9}} {
+		if col := doc.column(line, test.x); col != test.col {
+			t.Errorf("column of %d is %d, expected %d", test.x, col, test.col)
+		}
+	}
+	for _, test := range []struct{ col, x int }{{0, 0}, {3, 0}, {4, 1}, {6, 2}, {8, 3}, {20, 4}} {
+		if x := doc.runeIndex(line, test.col); x != test.x {
+			t.Errorf("rune at column %d is %d, expected %d", test.col, x, test.x)
+		}
+	}
+
+	// moving up and down keeps the column
+	doc.absolutCursor = CursorStruct{x: 5, y: 1}
+	doc.updateWantX()
+	doc.handleEventCursorUp()
+	if doc.absolutCursor.x != 2 {
+		t.Errorf("cursor moved up to %d, expected 2 behind the tab", doc.absolutCursor.x)
+	}
+	doc.handleEventCursorDown()
+	if doc.absolutCursor.x != 5 {
+		t.Errorf("cursor moved down to %d, expected 5", doc.absolutCursor.x)
+	}
+
+	// tabs are rendered up to the next tab stop
+	screen := doc.screen.Screen.(tcell.SimulationScreen)
+	doc.absolutCursor = CursorStruct{x: 3, y: 0}
+	doc.renderScreen()
+	doc.showCursor()
+	got := ""
+	for x := 0; x < 10; x++ {
+		r, _, _, _ := screen.GetContent(x, 0)
+		got += string(r)
+	}
+	if got != "    x   y " {
+		t.Errorf("rendered %q", got)
+	}
+	if x, _, _ := screen.GetCursor(); x != 8 {
+		t.Errorf("cursor shown at column %d, expected 8", x)
+	}
+
+	// the tab key inserts a tab or spaces up to the next tab stop
+	doc.absolutCursor = CursorStruct{x: 1, y: 1}
+	doc.expandTabs = true
+	doc.handleEventInsertTab()
+	doc.expandTabs = false
+	doc.handleEventInsertTab()
+	if string(doc.Line(1)) != "a   \tbcdefgh" || doc.absolutCursor.x != 5 {
+		t.Errorf("got %q, cursor %d", doc.Line(1), doc.absolutCursor.x)
+	}
+}
+
+func TestGraphemes(t *testing.T) {
+	// e with a combining accent, two wide characters and an emoji with
+	// a skin tone
+	doc := newTestDoc(t, "e\u0301x日本\U0001F44B\U0001F3FBz", "abcdefghij")
+	line := doc.Line(0)
+	for _, test := range []struct{ x, col int }{{0, 0}, {2, 1}, {3, 2}, {4, 4}, {5, 6}, {7, 8}, {8, 9}} {
+		if col := doc.column(line, test.x); col != test.col {
+			t.Errorf("column of %d is %d, expected %d", test.x, col, test.col)
+		}
+	}
+	for _, test := range []struct{ col, x int }{{0, 0}, {1, 2}, {3, 3}, {5, 4}, {7, 5}, {8, 7}} {
+		if x := doc.runeIndex(line, test.col); x != test.x {
+			t.Errorf("rune at column %d is %d, expected %d", test.col, x, test.x)
+		}
+	}
+
+	// the cursor skips combining marks and skin tones
+	right := tcell.NewEventKey(tcell.KeyRight, 0, tcell.ModNone)
+	left := tcell.NewEventKey(tcell.KeyLeft, 0, tcell.ModNone)
+	var positions []int
+	for i := 0; i < 6; i++ {
+		doc.handleEventCursorRight(right)
+		positions = append(positions, doc.absolutCursor.x)
+	}
+	if fmt.Sprint(positions) != "[2 3 4 5 7 8]" {
+		t.Errorf("cursor moved right to %v", positions)
+	}
+	doc.handleEventCursorLeft(left)
+	if doc.absolutCursor.x != 7 {
+		t.Errorf("cursor moved left to %d, expected 7", doc.absolutCursor.x)
+	}
+
+	// words are made of clusters
+	doc.SetLine(nil, 1, LineType("a\u0301b c\u0301d"))
+	doc.absolutCursor = CursorStruct{x: 0, y: 1}
+	ctrlRight := tcell.NewEventKey(tcell.KeyRight, 0, tcell.ModCtrl)
+	positions = nil
+	for i := 0; i < 2; i++ {
+		doc.handleEventCursorRight(ctrlRight)
+		positions = append(positions, doc.absolutCursor.x)
+	}
+	if fmt.Sprint(positions) != "[3 7]" {
+		t.Errorf("cursor moved a word right to %v", positions)
+	}
+	doc.absolutCursor.x = 3
+	doc.handleEventCursorLeft(tcell.NewEventKey(tcell.KeyLeft, 0, tcell.ModCtrl))
+	if doc.absolutCursor.x != 0 {
+		t.Errorf("cursor moved a word left to %d, expected 0", doc.absolutCursor.x)
+	}
+	doc.SetLine(nil, 1, LineType("abcdefghij"))
+
+	// moving down from behind a wide character keeps the column
+	doc.absolutCursor = CursorStruct{x: 4, y: 0}
+	doc.updateWantX()
+	doc.handleEventCursorDown()
+	if doc.absolutCursor.x != 4 {
+		t.Errorf("cursor moved down to %d, expected 4", doc.absolutCursor.x)
+	}
+	doc.absolutCursor = CursorStruct{x: 5, y: 1}
+	doc.updateWantX()
+	doc.handleEventCursorUp()
+	if doc.absolutCursor.x != 4 {
+		t.Errorf("cursor moved up into a wide character to %d, expected 4", doc.absolutCursor.x)
+	}
+
+	// the cursor is shown behind the wide characters, combining marks are
+	// drawn in the cell of their base
+	screen := doc.screen.Screen.(tcell.SimulationScreen)
+	doc.absolutCursor = CursorStruct{x: 5, y: 0}
+	doc.renderScreen()
+	doc.showCursor()
+	if x, _, _ := screen.GetCursor(); x != 6 {
+		t.Errorf("cursor shown at column %d, expected 6", x)
+	}
+	if r, comb, _, _ := screen.GetContent(0, 0); r != 'e' || string(comb) != "\u0301" {
+		t.Errorf("first cell is %q %q", r, comb)
+	}
+	if r, _, _, _ := screen.GetContent(1, 0); r != 'x' {
+		t.Errorf("second cell is %q, expected x", r)
+	}
+
+	// backspace and delete remove whole clusters
+	doc.absolutCursor = CursorStruct{x: 7, y: 0}
+	doc.handleEventBackspace()
+	doc.absolutCursor = CursorStruct{x: 0, y: 0}
+	doc.handleEventDelete()
+	if string(doc.Line(0)) != "x日本z" || doc.absolutCursor.x != 0 {
+		t.Errorf("got %q, cursor %d", doc.Line(0), doc.absolutCursor.x)
+	}
+}
+
+func TestWrap(t *testing.T) {
+	doc := newTestDoc(t, "  one two three four five six seven", "x", "abcdefghijklmnopqrstuvwxyz0123456789")
+	screen := doc.screen.Screen.(tcell.SimulationScreen)
+	screen.SetSize(16, 6)
+	rowText := func(row int) string {
+		s := ""
+		for x := 0; x < 16; x++ {
+			r, _, _, _ := screen.GetContent(x, row)
+			s += string(r)
+		}
+		return s
+	}
+
+	// rows are 15 columns wide, the rows after the first are indented and
+	// a blank may take the last column
+	doc.wrap = WrapWords
+	starts, indent := doc.wrapLine(doc.Line(0))
+	if fmt.Sprint(starts) != "[0 16 26]" || indent != 3 {
+		t.Errorf("words wrapped at %v with indent %d", starts, indent)
+	}
+	doc.wrap = WrapChars
+	starts, _ = doc.wrapLine(doc.Line(2))
+	if fmt.Sprint(starts) != "[0 15 29]" {
+		t.Errorf("wrapped at %v", starts)
+	}
+
+	doc.wrap = WrapWords
+	doc.renderScreen()
+	// the info line covers the first row
+	for i, want := range []string{"  ↪four five    ", "  ↪six seven    ", "x               "} {
+		if got := rowText(i + 1); got != want {
+			t.Errorf("row %d is %q, expected %q", i+1, got, want)
+		}
+	}
+
+	// up and down move by rows and keep the column
+	doc.absolutCursor = CursorStruct{x: 8, y: 0}
+	doc.updateWantX()
+	doc.handleEventCursorDown()
+	if doc.absolutCursor != (CursorStruct{x: 21, y: 0, wantX: 8}) {
+		t.Errorf("cursor moved down to %+v", doc.absolutCursor)
+	}
+	doc.handleEventCursorDown()
+	doc.handleEventCursorDown()
+	if doc.absolutCursor.x != 1 || doc.absolutCursor.y != 1 {
+		t.Errorf("cursor moved down to %+v, expected the end of line 1", doc.absolutCursor)
+	}
+	doc.handleEventCursorUp()
+	if doc.absolutCursor.x != 31 || doc.absolutCursor.y != 0 {
+		t.Errorf("cursor moved up to %+v, expected row 2 of line 0", doc.absolutCursor)
+	}
+
+	// page down counts rows and scrolls by rows
+	doc.handleEventPageDown()
+	if doc.absolutCursor.y != 2 || doc.absolutCursor.x != 36 {
+		t.Errorf("page down moved the cursor to %+v", doc.absolutCursor)
+	}
+	doc.showCursor()
+	if x, y, _ := screen.GetCursor(); x != 8 || y != 4 {
+		t.Errorf("cursor shown at %d,%d", x, y)
+	}
+	if doc.viewport.y != 0 || doc.topRow != 2 {
+		t.Errorf("viewport starts at row %d of line %d", doc.topRow, doc.viewport.y)
+	}
+
+	// the selection continues in the wrapped rows
+	doc.screen.selectionStyle = tcell.StyleDefault.Reverse(true)
+	doc.selection = selectionStruct{begin: xyStruct{x: 10, y: 2}, end: xyStruct{x: 20, y: 2}}
+	doc.renderScreen()
+	for _, cell := range []struct{ x, y int }{{14, 2}, {1, 3}, {6, 3}} {
+		if _, _, style, _ := screen.GetContent(cell.x, cell.y); style != doc.screen.selectionStyle {
+			t.Errorf("cell %d,%d isn't selected", cell.x, cell.y)
+		}
+	}
+	if _, _, style, _ := screen.GetContent(7, 3); style == doc.screen.selectionStyle {
+		t.Errorf("cell behind the selection is selected")
+	}
+}
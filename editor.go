@@ -0,0 +1,466 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/gdamore/tcell/v2"
+	"jostermeier.de/edit/buffer"
+)
+
+// EditorStruct holds the open documents and the windows showing them, key
+// events go to the document of the focused window
+type EditorStruct struct {
+	screen   ScreenStruct
+	docs     []*DocStruct
+	current  int
+	previous int // document shown before the current one
+	root     *WindowStruct
+	focus    *WindowStruct
+	terminal io.Writer // for the window title, nil if not available
+	title    string
+	watch    func(dir string) // watches a directory for changes, nil if not supported
+}
+
+var editor EditorStruct
+
+func newDoc(filename string) *DocStruct {
+	buf := newBuffer(filename)
+	buf.tabWidth = config.getInt("tab_width", defaultTabWidth)
+	buf.expandTabs = config.getBool("expand_tabs", true)
+	return &DocStruct{
+		BufferStruct:   buf,
+		screen:         editor.screen,
+		absolutCursor:  CursorStruct{x: 0, y: 0, wantX: 0},
+		previousCursor: CursorStruct{x: 0, y: 0, wantX: 0},
+		viewport:       xyStruct{x: 0, y: 0},
+		selection:      emptySelection,
+		lineNumbers:    defaultLineNumbers(),
+		wrap:           defaultWrap(),
+	}
+}
+
+// defaultLineNumbers returns the line numbers mode set in the config
+func defaultLineNumbers() LineNumbersType {
+	ln, _ := parseLineNumbers(config.get("line_numbers", "off"))
+	return ln
+}
+
+// defaultWrap returns the wrap mode set in the config
+func defaultWrap() WrapType {
+	wrap, _ := parseWrap(config.get("wrap", "off"))
+	return wrap
+}
+
+// applyIndent makes the tab key insert what the language of syn prefers,
+// languages like Go and make need tabs. Without a preference the config
+// applies.
+func (doc *DocStruct) applyIndent(syn *SyntaxStruct) {
+	doc.expandTabs = config.getBool("expand_tabs", true)
+	if syn != nil && syn.indent != "" {
+		doc.expandTabs = syn.indent == "spaces"
+	}
+}
+
+// loadDoc reads the file into a new document, a file which doesn't exist
+// yet gives an empty document
+func loadDoc(filename, encodingName string, readonly bool) (*DocStruct, error) {
+	doc := newDoc(filename)
+	doc.readonly = readonly
+	doc.encodingName = encodingName
+	err := doc.handleEventLoad()
+	if errors.Is(err, os.ErrNotExist) {
+		doc.SetText(buffer.NewPieceText(LineType{}))
+		if encodingName != "" {
+			doc.format.encoding, _ = lookupEncoding(encodingName)
+			doc.savedFormat = doc.format
+		}
+	} else if err != nil {
+		return nil, err
+	}
+
+	// restore undo history of previous sessions
+	if err := doc.loadUndoHistory(); err != nil && !errors.Is(err, os.ErrNotExist) && !errors.Is(err, errUndoFileOutdated) {
+		doc.setError("undo history not loaded: %v", err)
+	}
+	if err := doc.openSwap(); err != nil {
+		doc.setError("%v", err)
+	}
+	syn := detectSyntax(filename, doc.Line(0))
+	doc.applyIndent(syn)
+	if config.getBool("highlight", true) {
+		doc.highlight = newHighlight(syn)
+	}
+	return doc, nil
+}
+
+func (ed *EditorStruct) doc() *DocStruct {
+	return ed.focus.doc
+}
+
+// index returns the position of the buffer of doc in the document list, or -1
+func (ed *EditorStruct) index(doc *DocStruct) int {
+	for i, d := range ed.docs {
+		if d.BufferStruct == doc.BufferStruct {
+			return i
+		}
+	}
+	return -1
+}
+
+// findDoc returns the index of the open document of filename, or -1
+func (ed *EditorStruct) findDoc(filename string) int {
+	abs, err := filepath.Abs(filename)
+	if err != nil {
+		return -1
+	}
+	for i, doc := range ed.docs {
+		if docAbs, err := filepath.Abs(doc.filename); err == nil && docAbs == abs {
+			return i
+		}
+	}
+	return -1
+}
+
+// openDoc shows the document of filename, it is loaded if it isn't open yet
+func (ed *EditorStruct) openDoc(filename string) (*DocStruct, error) {
+	if i := ed.findDoc(filename); i >= 0 {
+		ed.show(ed.docs[i])
+		return ed.doc(), nil
+	}
+	doc, err := loadDoc(filename, "", false)
+	if err != nil {
+		return nil, err
+	}
+	ed.addDoc(doc)
+	ed.show(doc)
+	if doc.swap.stale != nil {
+		ed.doc().handleEventRecover()
+	}
+	return ed.doc(), nil
+}
+
+func (ed *EditorStruct) addDoc(doc *DocStruct) {
+	ed.docs = append(ed.docs, doc)
+	ed.watchFile(doc)
+}
+
+// show makes doc the current document, shown in the focused window
+func (ed *EditorStruct) show(doc *DocStruct) {
+	if i := ed.index(doc); i >= 0 && i != ed.current {
+		ed.previous = ed.current
+		ed.current = i
+	}
+	doc = ed.showIn(ed.focus, doc)
+	doc.adjustViewport()
+	doc.renderScreen()
+}
+
+// handleEventAlternateDoc switches back to the document shown before
+func (ed *EditorStruct) handleEventAlternateDoc() {
+	if ed.previous == ed.current || ed.previous >= len(ed.docs) {
+		ed.doc().setStatus("no other document")
+		return
+	}
+	ed.show(ed.docs[ed.previous])
+}
+
+// handleKeyEvent handles the keys which work on the list of documents,
+// it returns false for all other keys
+func (ed *EditorStruct) handleKeyEvent(event *tcell.EventKey) bool {
+	switch {
+	case event.Key() == tcell.KeyF2:
+		ed.handleEventAlternateDoc()
+	case event.Key() == tcell.KeyPgDn && event.Modifiers()&tcell.ModCtrl != 0:
+		ed.handleEventCycleDoc(1)
+	case event.Key() == tcell.KeyPgUp && event.Modifiers()&tcell.ModCtrl != 0:
+		ed.handleEventCycleDoc(-1)
+	case event.Key() == tcell.KeyCtrlO:
+		ed.handleEventOpen()
+	case event.Key() == tcell.KeyCtrlB:
+		ed.handleEventSwitchDoc()
+	case event.Key() == tcell.KeyCtrlW:
+		ed.handleEventCloseDoc(ed.doc())
+	case event.Modifiers()&tcell.ModAlt != 0:
+		return ed.handleWindowKeyEvent(event)
+	default:
+		return false
+	}
+	return true
+}
+
+// handleWindowKeyEvent handles the Alt keys to split, close and switch windows
+func (ed *EditorStruct) handleWindowKeyEvent(event *tcell.EventKey) bool {
+	switch {
+	case event.Key() == tcell.KeyRune && event.Rune() == '-':
+		ed.handleEventSplitWindow(false)
+	case event.Key() == tcell.KeyRune && event.Rune() == '\\':
+		ed.handleEventSplitWindow(true)
+	case event.Key() == tcell.KeyRune && event.Rune() == 'w':
+		ed.handleEventCloseWindow()
+	case event.Key() == tcell.KeyLeft:
+		ed.handleEventFocusWindow(-1, 0)
+	case event.Key() == tcell.KeyRight:
+		ed.handleEventFocusWindow(1, 0)
+	case event.Key() == tcell.KeyUp:
+		ed.handleEventFocusWindow(0, -1)
+	case event.Key() == tcell.KeyDown:
+		ed.handleEventFocusWindow(0, 1)
+	default:
+		return false
+	}
+	return true
+}
+
+func (ed *EditorStruct) handleEventCycleDoc(step int) {
+	n := len(ed.docs)
+	ed.show(ed.docs[((ed.current+step)%n+n)%n])
+	ed.doc().setStatus("%s (%d of %d)", ed.doc().filename, ed.current+1, n)
+}
+
+func (ed *EditorStruct) handleEventOpen() {
+	ed.doc().openPrompt(&PromptStruct{
+		label: "open: ",
+		onEnter: func(filename string) {
+			if filename == "" {
+				return
+			}
+			if _, err := ed.openDoc(filename); err != nil {
+				ed.doc().setError("%v", err)
+			}
+		},
+	})
+}
+
+// docName is the name of a document in the document list
+func (doc *DocStruct) docName() string {
+	if doc.modified() {
+		return doc.filename + " [+]"
+	}
+	return doc.filename
+}
+
+// fuzzyMatch reports whether the runes of pattern appear in s in this order,
+// ignoring case. Lower scores are better matches.
+func fuzzyMatch(s, pattern string) (score int, ok bool) {
+	runes := []rune(strings.ToLower(s))
+	first, last := -1, -1
+	i := 0
+	for _, p := range strings.ToLower(pattern) {
+		for i < len(runes) && runes[i] != p {
+			i++
+		}
+		if i >= len(runes) {
+			return 0, false
+		}
+		if first < 0 {
+			first = i
+		}
+		last = i
+		i++
+	}
+	// prefer compact matches, then short names
+	return (last-first)*1000 + len(runes), true
+}
+
+// handleEventSwitchDoc shows the list of documents above the status line,
+// typing filters the list, Up and Down select and Enter switches
+func (ed *EditorStruct) handleEventSwitchDoc() {
+	doc := ed.doc()
+	var candidates []*DocStruct
+	selected := 0
+	filter := func(input string) {
+		candidates = candidates[:0]
+		scores := map[*DocStruct]int{}
+		for _, d := range ed.docs {
+			if score, ok := fuzzyMatch(d.filename, input); ok {
+				candidates = append(candidates, d)
+				scores[d] = score
+			}
+		}
+		sort.SliceStable(candidates, func(i, j int) bool {
+			return scores[candidates[i]] < scores[candidates[j]]
+		})
+		selected = 0
+	}
+	filter("")
+
+	prompt := &PromptStruct{label: "switch to: "}
+	prompt.onChange = func(input string) {
+		filter(input)
+		doc.renderScreen()
+	}
+	prompt.onKey = func(event *tcell.EventKey) bool {
+		switch event.Key() {
+		case tcell.KeyUp:
+			if selected+1 < len(candidates) {
+				selected++
+			}
+		case tcell.KeyDown:
+			if selected > 0 {
+				selected--
+			}
+		default:
+			return false
+		}
+		doc.renderScreen()
+		return true
+	}
+	prompt.onRender = func() {
+		// the best match is shown directly above the prompt
+		_, maxy := doc.screen.Size()
+		for i, d := range candidates {
+			y := maxy - 2 - i
+			if y < 0 {
+				break
+			}
+			style := doc.screen.infoStyle
+			if i == selected {
+				style = style.Reverse(true)
+			}
+			doc.renderString(0, y, " "+d.docName()+" ", style)
+		}
+	}
+	prompt.onEnter = func(string) {
+		if len(candidates) == 0 {
+			doc.renderScreen()
+			return
+		}
+		ed.show(candidates[selected])
+	}
+	prompt.onCancel = func() {
+		doc.renderScreen()
+	}
+	doc.openPrompt(prompt)
+	doc.renderScreen()
+}
+
+// confirmUnsaved asks whether the changes of doc should be saved or
+// discarded, proceed is called unless the user cancels or saving fails
+func (ed *EditorStruct) confirmUnsaved(doc *DocStruct, proceed func(saved bool)) {
+	doc.openPrompt(&PromptStruct{
+		label: fmt.Sprintf("%s has unsaved changes: (s)ave (d)iscard (c)ancel", doc.filename),
+		onKey: func(event *tcell.EventKey) bool {
+			if event.Key() == tcell.KeyEscape || event.Key() == tcell.KeyCtrlC {
+				doc.closePrompt()
+				return true
+			}
+			if event.Key() != tcell.KeyRune {
+				return true
+			}
+			switch event.Rune() {
+			case 's':
+				doc.closePrompt()
+				if doc.handleEventSaveWithStatus() {
+					proceed(true)
+				}
+			case 'd':
+				doc.closePrompt()
+				proceed(false)
+			case 'c':
+				doc.closePrompt()
+			}
+			return true
+		},
+	})
+}
+
+// handleEventCloseDoc closes doc, asking to save unsaved changes first.
+// Closing the last document quits the editor.
+func (ed *EditorStruct) handleEventCloseDoc(doc *DocStruct) {
+	if !doc.modified() {
+		ed.closeDoc(doc)
+		return
+	}
+	ed.confirmUnsaved(doc, func(bool) {
+		ed.closeDoc(doc)
+	})
+}
+
+// handleEventQuit quits the editor, documents with unsaved changes are shown
+// one after the other asking to save or discard them
+func (ed *EditorStruct) handleEventQuit() {
+	ed.confirmQuit(map[*BufferStruct]bool{})
+}
+
+func (ed *EditorStruct) confirmQuit(discarded map[*BufferStruct]bool) {
+	for _, doc := range ed.docs {
+		if !doc.modified() || discarded[doc.BufferStruct] {
+			continue
+		}
+		ed.show(doc)
+		ed.confirmUnsaved(ed.doc(), func(saved bool) {
+			if !saved {
+				discarded[doc.BufferStruct] = true
+			}
+			ed.confirmQuit(discarded)
+		})
+		return
+	}
+	ed.quit()
+}
+
+func (ed *EditorStruct) closeDoc(doc *DocStruct) {
+	if doc.grep != nil {
+		doc.grep.cancel()
+	}
+	i := ed.index(doc)
+	if i < 0 {
+		return
+	}
+	doc.removeSwap()
+	ed.docs = append(ed.docs[:i], ed.docs[i+1:]...)
+	if len(ed.docs) == 0 {
+		ed.quit()
+	}
+	if ed.previous > i || ed.previous >= len(ed.docs) {
+		ed.previous--
+	}
+	if ed.previous < 0 {
+		ed.previous = 0
+	}
+	// windows of the closed document continue with the one shown before
+	ed.current = ed.previous
+	for _, w := range ed.root.leaves() {
+		if w.doc.BufferStruct == doc.BufferStruct {
+			ed.showIn(w, ed.docs[ed.current]).adjustViewport()
+		}
+	}
+	ed.setFocus(ed.focus)
+	ed.renderWindows()
+}
+
+func (ed *EditorStruct) quit() {
+	for _, doc := range ed.docs {
+		doc.removeSwap()
+	}
+	if ed.terminal != nil {
+		// restore the title saved at start
+		io.WriteString(ed.terminal, "\x1b[23;2t")
+	}
+	ed.screen.Fini()
+	os.Exit(0)
+}
+
+// updateTitle shows the current document in the title of the terminal window
+func (ed *EditorStruct) updateTitle() {
+	if ed.terminal == nil {
+		return
+	}
+	title := strings.Map(func(r rune) rune {
+		if r < ' ' || r == 0x7f {
+			return -1 // control characters would end the escape sequence
+		}
+		return r
+	}, ed.doc().docName()+" - edit")
+	if title == ed.title {
+		return
+	}
+	ed.title = title
+	io.WriteString(ed.terminal, "\x1b]2;"+title+"\x07")
+}
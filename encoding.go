@@ -0,0 +1,334 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding/charmap"
+	"jostermeier.de/edit/buffer"
+)
+
+// Bytes which aren't valid in the encoding of the file are stored as runes
+// rawByteBase+b (lone low surrogates, never valid in decoded text), so
+// saving an untouched file writes exactly the bytes that were read. In
+// utf-16 files both bytes of an invalid code unit are stored this way.
+const rawByteBase = 0xDC00
+
+func isRawByte(r rune) bool {
+	return r >= rawByteBase && r <= rawByteBase+0xFF
+}
+
+// EncodingStruct converts between the bytes of a file and the lines of a document
+type EncodingStruct struct {
+	name    string
+	charmap *charmap.Charmap // 8 bit encodings
+	utf16   binary.ByteOrder // utf-16 encodings
+}
+
+var encodings = []EncodingStruct{
+	{name: "utf-8"},
+	{name: "utf-16le", utf16: binary.LittleEndian},
+	{name: "utf-16be", utf16: binary.BigEndian},
+	{name: "latin-1", charmap: charmap.ISO8859_1},
+	{name: "latin-9", charmap: charmap.ISO8859_15},
+	{name: "windows-1252", charmap: charmap.Windows1252},
+}
+
+var encodingAliases = map[string]string{
+	"utf8":        "utf-8",
+	"utf-16":      "utf-16be",
+	"utf16":       "utf-16be",
+	"utf16le":     "utf-16le",
+	"utf16be":     "utf-16be",
+	"latin1":      "latin-1",
+	"iso-8859-1":  "latin-1",
+	"iso8859-1":   "latin-1",
+	"latin9":      "latin-9",
+	"iso-8859-15": "latin-9",
+	"cp1252":      "windows-1252",
+}
+
+var utf8Encoding = encodings[0]
+
+func lookupEncoding(name string) (EncodingStruct, error) {
+	name = strings.ToLower(name)
+	if alias, ok := encodingAliases[name]; ok {
+		name = alias
+	}
+	for _, e := range encodings {
+		if e.name == name {
+			return e, nil
+		}
+	}
+	return EncodingStruct{}, fmt.Errorf("unsupported encoding %q", name)
+}
+
+// detectEncoding looks at the byte order mark and checks if data is valid
+// utf-8. Files which aren't are opened with the configured fallback encoding,
+// or as utf-8 with the invalid bytes preserved.
+func detectEncoding(data []byte) EncodingStruct {
+	if bytes.HasPrefix(data, []byte{0xFF, 0xFE}) {
+		e, _ := lookupEncoding("utf-16le")
+		return e
+	}
+	if bytes.HasPrefix(data, []byte{0xFE, 0xFF}) {
+		e, _ := lookupEncoding("utf-16be")
+		return e
+	}
+	if !utf8.Valid(data) {
+		if e, err := lookupEncoding(config.get("fallback_encoding", "utf-8")); err == nil {
+			return e
+		}
+	}
+	return utf8Encoding
+}
+
+// decodeText converts the content of a file into a text, which decodes the
+// lines when they are used. mapping is set if data is a mapped file.
+func (e EncodingStruct) decodeText(data []byte, mapping *mappingStruct) (*buffer.PieceText, FileFormatStruct) {
+	bom := false
+	if e.utf16 != nil && len(data) >= 2 && e.utf16.Uint16(data) == 0xFEFF {
+		bom = true
+		data = data[2:]
+	} else if e.charmap == nil && e.utf16 == nil && bytes.HasPrefix(data, utf8Bom) {
+		bom = true
+		data = data[len(utf8Bom):]
+	}
+
+	var starts []int
+	var format FileFormatStruct
+	if e.utf16 != nil {
+		starts, format = indexUTF16Lines(e.utf16, data)
+	} else {
+		starts, format = indexLines(data)
+	}
+	format.bom = bom
+	format.encoding = e
+
+	file := &buffer.FileLines{Data: data, Starts: starts}
+	file.Decode = func(b []byte) LineType { return decodeUTF8Line(trimLineEnding(b)) }
+	if e.charmap != nil {
+		cm := e.charmap
+		file.Decode = func(b []byte) LineType { return decodeCharmapLine(cm, trimLineEnding(b)) }
+	}
+	if order := e.utf16; order != nil {
+		file.Decode = func(b []byte) LineType { return decodeUTF16Line(order, trimUTF16LineEnding(order, b)) }
+	}
+	if format.mixed {
+		if order := e.utf16; order != nil {
+			trim := func(b []byte) []byte { return trimUTF16LineEnding(order, b) }
+			last := func(b []byte) byte { return byte(order.Uint16(b[len(b)-2:])) }
+			file.Endings = lineEndings(data, starts, format, 2, trim, last)
+		} else {
+			last := func(b []byte) byte { return b[len(b)-1] }
+			file.Endings = lineEndings(data, starts, format, 1, trimLineEnding, last)
+		}
+	}
+	if mapping != nil {
+		file.Mapping = mapping
+	}
+	return buffer.NewFileText(file), format
+}
+
+// decode converts the content of a file into lines
+func (e EncodingStruct) decode(data []byte) ([]LineType, FileFormatStruct) {
+	text, format := e.decodeText(data, nil)
+	return buffer.AllLines(text), format
+}
+
+// encode is the reverse of decode, it fails for characters which can't be
+// represented in the encoding
+func (e EncodingStruct) encode(text buffer.Text, format FileFormatStruct) ([]byte, error) {
+	byteLines := make([][]byte, 0, text.Len())
+	var err error
+	text.Each(0, func(row int, line LineType) bool {
+		var byteLine []byte
+		if e.charmap != nil {
+			byteLine, err = encodeCharmapLine(e.charmap, line)
+		} else if e.utf16 != nil {
+			byteLine = encodeUTF16Line(e.utf16, line)
+		} else {
+			byteLine = encodeUTF8Line(line)
+		}
+		if err != nil {
+			err = fmt.Errorf("line %d: %v", row+1, err)
+			return false
+		}
+		byteLines = append(byteLines, byteLine)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var newlines [3][]byte
+	for le := range newlines {
+		newlines[le] = LineEndingType(le).bytes()
+		if e.utf16 != nil {
+			newlines[le] = encodeUTF16Line(e.utf16, LineType(string(newlines[le])))
+		}
+	}
+	newline := func(row int) []byte {
+		if format.mixed {
+			// unchanged lines keep their line ending
+			if pt, ok := text.(*buffer.PieceText); ok {
+				if le, ok := pt.Ending(row); ok {
+					return newlines[le]
+				}
+			}
+		}
+		return newlines[format.lineEnding]
+	}
+	data := joinLines(byteLines, newline, format.finalNewline)
+	if format.bom && e.utf16 != nil {
+		return append(encodeUTF16Line(e.utf16, LineType{0xFEFF}), data...), nil
+	}
+	if format.bom && e.charmap == nil {
+		data = append(append([]byte{}, utf8Bom...), data...)
+	}
+	return data, nil
+}
+
+func decodeUTF8Line(b []byte) LineType {
+	if utf8.Valid(b) {
+		return LineType(string(b))
+	}
+	line := make(LineType, 0, len(b))
+	for len(b) > 0 {
+		r, size := utf8.DecodeRune(b)
+		if r == utf8.RuneError && size == 1 {
+			r = rawByteBase + rune(b[0])
+		}
+		line = append(line, r)
+		b = b[size:]
+	}
+	return line
+}
+
+func encodeUTF8Line(line LineType) []byte {
+	b := make([]byte, 0, len(line))
+	var buf [utf8.UTFMax]byte
+	for _, r := range line {
+		if isRawByte(r) {
+			b = append(b, byte(r-rawByteBase))
+			continue
+		}
+		n := utf8.EncodeRune(buf[:], r)
+		b = append(b, buf[:n]...)
+	}
+	return b
+}
+
+func decodeCharmapLine(cm *charmap.Charmap, b []byte) LineType {
+	line := make(LineType, len(b))
+	for i, c := range b {
+		r := cm.DecodeByte(c)
+		if r == utf8.RuneError && c >= 0x80 {
+			// byte is undefined in this code page
+			r = rawByteBase + rune(c)
+		}
+		line[i] = r
+	}
+	return line
+}
+
+func encodeCharmapLine(cm *charmap.Charmap, line LineType) ([]byte, error) {
+	b := make([]byte, len(line))
+	for i, r := range line {
+		if isRawByte(r) {
+			b[i] = byte(r - rawByteBase)
+			continue
+		}
+		c, ok := cm.EncodeRune(r)
+		if !ok {
+			return nil, fmt.Errorf("character %q can't be encoded in %s", r, cm)
+		}
+		b[i] = c
+	}
+	return b, nil
+}
+
+// indexUTF16Lines is indexLines for utf-16 data. The lines are found in a
+// copy with a byte for every code unit, line feeds and carriage returns stay
+// and the other code units become 'x'.
+func indexUTF16Lines(order binary.ByteOrder, data []byte) ([]int, FileFormatStruct) {
+	units := make([]byte, (len(data)+1)/2)
+	for i := range units {
+		units[i] = 'x'
+		if 2*i+1 < len(data) {
+			if u := order.Uint16(data[2*i:]); u == '\n' || u == '\r' {
+				units[i] = byte(u)
+			}
+		}
+	}
+	starts, format := indexLines(units)
+	for i := range starts {
+		starts[i] *= 2
+		if starts[i] > len(data) {
+			// an odd byte at the end
+			starts[i] = len(data)
+		}
+	}
+	return starts, format
+}
+
+// trimUTF16LineEnding is trimLineEnding for utf-16 lines
+func trimUTF16LineEnding(order binary.ByteOrder, line []byte) []byte {
+	for _, ending := range []uint16{'\n', '\r'} {
+		if n := len(line); n >= 2 && n%2 == 0 && order.Uint16(line[n-2:]) == ending {
+			line = line[:n-2]
+		}
+	}
+	return line
+}
+
+func decodeUTF16Line(order binary.ByteOrder, b []byte) LineType {
+	line := make(LineType, 0, len(b)/2)
+	for i := 0; i < len(b); i += 2 {
+		if i+1 == len(b) {
+			// an odd byte at the end of the file
+			line = append(line, rawByteBase+rune(b[i]))
+			break
+		}
+		u := rune(order.Uint16(b[i:]))
+		if !utf16.IsSurrogate(u) {
+			line = append(line, u)
+			continue
+		}
+		if i+3 < len(b) {
+			if r := utf16.DecodeRune(u, rune(order.Uint16(b[i+2:]))); r != utf8.RuneError {
+				line = append(line, r)
+				i += 2
+				continue
+			}
+		}
+		// a lone surrogate keeps its bytes
+		line = append(line, rawByteBase+rune(b[i]), rawByteBase+rune(b[i+1]))
+	}
+	return line
+}
+
+func encodeUTF16Line(order binary.ByteOrder, line LineType) []byte {
+	b := make([]byte, 0, 2*len(line))
+	var unit [2]byte
+	appendUnit := func(r rune) {
+		order.PutUint16(unit[:], uint16(r))
+		b = append(b, unit[:]...)
+	}
+	for _, r := range line {
+		if isRawByte(r) {
+			b = append(b, byte(r-rawByteBase))
+		} else if r >= 0x10000 {
+			r1, r2 := utf16.EncodeRune(r)
+			appendUnit(r1)
+			appendUnit(r2)
+		} else {
+			appendUnit(r)
+		}
+	}
+	return b
+}
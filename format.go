@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+)
+
+type LineEndingType int
+
+const (
+	LineEndingLF LineEndingType = iota
+	LineEndingCRLF
+	LineEndingCR
+)
+
+func (le LineEndingType) String() string {
+	switch le {
+	case LineEndingCRLF:
+		return "CRLF"
+	case LineEndingCR:
+		return "CR"
+	default:
+		return "LF"
+	}
+}
+
+func (le LineEndingType) bytes() []byte {
+	switch le {
+	case LineEndingCRLF:
+		return []byte("\r\n")
+	case LineEndingCR:
+		return []byte("\r")
+	default:
+		return []byte("\n")
+	}
+}
+
+func parseLineEnding(s string) (LineEndingType, bool) {
+	switch strings.ToLower(s) {
+	case "lf", "unix":
+		return LineEndingLF, true
+	case "crlf", "dos":
+		return LineEndingCRLF, true
+	case "cr", "mac":
+		return LineEndingCR, true
+	}
+	return LineEndingLF, false
+}
+
+// FileFormatStruct records how the text was stored in the file, so saving
+// writes it back the same way. In files with mixed line endings the lines
+// keep their own line ending, changed and new lines get the most frequent
+// one. The lineending command converts all lines.
+type FileFormatStruct struct {
+	lineEnding   LineEndingType
+	mixed        bool
+	bom          bool
+	finalNewline bool
+	encoding     EncodingStruct
+}
+
+var defaultFileFormat = FileFormatStruct{
+	lineEnding:   LineEndingLF,
+	finalNewline: true,
+	encoding:     utf8Encoding,
+}
+
+var utf8Bom = []byte{0xEF, 0xBB, 0xBF}
+
+func (format FileFormatStruct) String() string {
+	s := format.encoding.name + " " + format.lineEnding.String()
+	if format.mixed {
+		s = "mixed " + s
+	}
+	if format.bom {
+		s += " BOM"
+	}
+	if !format.finalNewline {
+		s += " noeol"
+	}
+	return s
+}
+
+// indexLines finds the offset of every line of data, followed by the length
+// of data, and detects line endings
+func indexLines(data []byte) ([]int, FileFormatStruct) {
+	format := FileFormatStruct{}
+
+	starts := make([]int, 1, 256)
+	count := [3]int{}
+	start := 0
+	for i := 0; i < len(data); i++ {
+		switch data[i] {
+		case '\n':
+			count[LineEndingLF]++
+			start = i + 1
+			starts = append(starts, start)
+		case '\r':
+			if i+1 < len(data) && data[i+1] == '\n' {
+				count[LineEndingCRLF]++
+				i++
+			} else {
+				count[LineEndingCR]++
+			}
+			start = i + 1
+			starts = append(starts, start)
+		}
+	}
+	// the rest after the last line ending is a line without line ending
+	format.finalNewline = len(data) > 0 && start == len(data)
+	if start < len(data) || len(starts) == 1 {
+		starts = append(starts, len(data))
+	}
+
+	kinds := 0
+	for le, c := range count {
+		if c > 0 {
+			kinds++
+		}
+		if c > count[format.lineEnding] {
+			format.lineEnding = LineEndingType(le)
+		}
+	}
+	format.mixed = kinds > 1
+	if kinds == 0 {
+		// nothing to detect, e.g. an empty file
+		format.lineEnding = defaultFileFormat.lineEnding
+	}
+	return starts, format
+}
+
+// lineEndings returns the line ending of every line of data, which has
+// mixed line endings. unit is the size of a character, trim removes the
+// line ending and last returns the last character of a line.
+func lineEndings(data []byte, starts []int, format FileFormatStruct, unit int, trim func([]byte) []byte, last func([]byte) byte) []byte {
+	endings := make([]byte, len(starts)-1)
+	for i := range endings {
+		line := data[starts[i]:starts[i+1]]
+		switch (len(line) - len(trim(line))) / unit {
+		case 2:
+			endings[i] = byte(LineEndingCRLF)
+		case 1:
+			if last(line) == '\n' {
+				endings[i] = byte(LineEndingLF)
+			} else {
+				endings[i] = byte(LineEndingCR)
+			}
+		default:
+			// the last line, lines added behind it get the usual one
+			endings[i] = byte(format.lineEnding)
+		}
+	}
+	return endings
+}
+
+// trimLineEnding returns line without the line ending at its end
+func trimLineEnding(line []byte) []byte {
+	if n := len(line); n > 0 && line[n-1] == '\n' {
+		line = line[:n-1]
+	}
+	if n := len(line); n > 0 && line[n-1] == '\r' {
+		line = line[:n-1]
+	}
+	return line
+}
+
+// splitLines splits data into lines and detects line endings
+func splitLines(data []byte) ([][]byte, FileFormatStruct) {
+	starts, format := indexLines(data)
+	lines := make([][]byte, len(starts)-1)
+	for i := range lines {
+		lines[i] = trimLineEnding(data[starts[i]:starts[i+1]])
+	}
+	return lines, format
+}
+
+// joinLines is the reverse of splitLines, newline returns the line ending
+// of line i
+func joinLines(lines [][]byte, newline func(i int) []byte, finalNewline bool) []byte {
+	var buffer bytes.Buffer
+	for i, line := range lines {
+		buffer.Write(line)
+		if i < len(lines)-1 || finalNewline {
+			buffer.Write(newline(i))
+		}
+	}
+	return buffer.Bytes()
+}
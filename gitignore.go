@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// IgnoreRuleStruct is a pattern of a .gitignore file
+type IgnoreRuleStruct struct {
+	re      *regexp.Regexp
+	negate  bool // pattern starting with '!' re-includes a path
+	dirOnly bool // pattern ending with '/' only matches directories
+}
+
+// IgnoreStruct holds the rules of the .gitignore files from the root of the
+// walk down to one directory, the rules of deeper directories come last
+type IgnoreStruct struct {
+	rules []IgnoreRuleStruct
+	bases []string // directory of each rule relative to the root, "" for the root
+}
+
+// globToRegexp converts a gitignore glob to a regular expression on slash
+// separated paths relative to the directory of the .gitignore file
+func globToRegexp(glob string) (*regexp.Regexp, error) {
+	anchored := strings.Contains(strings.TrimSuffix(glob, "/"), "/")
+	glob = strings.TrimPrefix(strings.TrimSuffix(glob, "/"), "/")
+
+	var b strings.Builder
+	if anchored {
+		b.WriteString("^")
+	} else {
+		// a pattern without slash matches in any directory
+		b.WriteString("^(?:.*/)?")
+	}
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch {
+		case strings.HasPrefix(glob[i:], "**/"):
+			b.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "/**") && i+3 == len(glob):
+			b.WriteString("/.*")
+			i += 2
+		case strings.HasPrefix(glob[i:], "**"):
+			b.WriteString(".*")
+			i++
+		case c == '*':
+			b.WriteString("[^/]*")
+		case c == '?':
+			b.WriteString("[^/]")
+		case c == '[':
+			end := strings.IndexByte(glob[i:], ']')
+			if end < 0 {
+				b.WriteString(`\[`)
+				continue
+			}
+			class := glob[i+1 : i+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + class + "]")
+			i += end
+		case c == '\\' && i+1 < len(glob):
+			i++
+			b.WriteString(regexp.QuoteMeta(string(glob[i])))
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("$")
+	return regexp.Compile(b.String())
+}
+
+// withFile returns the rules extended by the .gitignore file in dir, which
+// is base relative to the root of the walk
+func (ig IgnoreStruct) withFile(dir, base string) IgnoreStruct {
+	f, err := os.Open(filepath.Join(dir, ".gitignore"))
+	if err != nil {
+		return ig
+	}
+	defer f.Close()
+
+	// copy, the rules of the parent directory are shared by its siblings
+	extended := IgnoreStruct{
+		rules: append([]IgnoreRuleStruct{}, ig.rules...),
+		bases: append([]string{}, ig.bases...),
+	}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), " \t\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		rule := IgnoreRuleStruct{}
+		if strings.HasPrefix(line, "!") {
+			rule.negate = true
+			line = line[1:]
+		}
+		rule.dirOnly = strings.HasSuffix(line, "/")
+		re, err := globToRegexp(line)
+		if err != nil {
+			continue
+		}
+		rule.re = re
+		extended.rules = append(extended.rules, rule)
+		extended.bases = append(extended.bases, base)
+	}
+	return extended
+}
+
+// ignored reports whether the path rel (slash separated, relative to the
+// root of the walk) is excluded, the last matching rule wins. Ignored
+// directories are skipped by the walk, so their content isn't checked.
+func (ig IgnoreStruct) ignored(rel string, isDir bool) bool {
+	ignored := false
+	for i, rule := range ig.rules {
+		p := rel
+		if base := ig.bases[i]; base != "" {
+			if !strings.HasPrefix(rel, base+"/") {
+				continue
+			}
+			p = rel[len(base)+1:]
+		}
+		if rule.dirOnly && !isDir {
+			continue
+		}
+		if rule.re.MatchString(p) {
+			ignored = !rule.negate
+		}
+	}
+	return ignored
+}
+
+// joinSlash joins the slash separated relative path base and name
+func joinSlash(base, name string) string {
+	if base == "" {
+		return name
+	}
+	return path.Join(base, name)
+}
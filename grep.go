@@ -0,0 +1,284 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gdamore/tcell/v2"
+	"jostermeier.de/edit/buffer"
+)
+
+const (
+	grepMaxFileSize = 64 << 20 // larger files are skipped
+	grepMaxLineText = 300      // runes of a matching line shown in the results
+	grepBatchDelay  = 50 * time.Millisecond
+)
+
+// GrepStruct is the state of a search through the files below a directory.
+// The results are shown in a read only document, line 0 is a summary and
+// every other line is a match.
+type GrepStruct struct {
+	pattern   string
+	regexp    bool
+	root      string
+	cancel    context.CancelFunc
+	locations []GrepResultStruct // location of line i+1 of the results document
+	files     int                // number of files searched
+	done      bool
+}
+
+type GrepResultStruct struct {
+	path   string // relative to root
+	line   int    // 1-based
+	column int    // 1-based, in runes
+	text   string
+}
+
+// walkFiles sends the files below dir which aren't excluded by .gitignore
+func walkFiles(ctx context.Context, dir, rel string, ig IgnoreStruct, files chan<- string) {
+	ig = ig.withFile(dir, rel)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		entryRel := joinSlash(rel, name)
+		if name == ".git" || ig.ignored(entryRel, entry.IsDir()) {
+			continue
+		}
+		if entry.IsDir() {
+			walkFiles(ctx, filepath.Join(dir, name), entryRel, ig, files)
+		} else if entry.Type().IsRegular() {
+			select {
+			case files <- entryRel:
+			case <-ctx.Done():
+				return
+			}
+		}
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// grepFile returns the matching lines of a file, binary files are skipped
+func grepFile(root, rel string, re *regexp.Regexp) []GrepResultStruct {
+	name := filepath.Join(root, filepath.FromSlash(rel))
+	if info, err := os.Stat(name); err != nil || info.Size() > grepMaxFileSize {
+		return nil
+	}
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil
+	}
+	head := data
+	if len(head) > 8000 {
+		head = head[:8000]
+	}
+	if bytes.IndexByte(head, 0) >= 0 {
+		return nil
+	}
+
+	var results []GrepResultStruct
+	lines, _ := splitLines(data)
+	for i, line := range lines {
+		loc := re.FindIndex(line)
+		if loc == nil {
+			continue
+		}
+		text := decodeUTF8Line(line)
+		if len(text) > grepMaxLineText {
+			text = text[:grepMaxLineText]
+		}
+		results = append(results, GrepResultStruct{
+			path:   rel,
+			line:   i + 1,
+			column: utf8.RuneCount(line[:loc[0]]) + 1,
+			text:   string(text),
+		})
+	}
+	return results
+}
+
+// run searches the files with concurrent workers and hands the results over
+// to the event loop in batches, so they appear while the search is running
+func (grep *GrepStruct) run(ctx context.Context, re *regexp.Regexp, screen tcell.Screen, deliver func(results []GrepResultStruct, files int, done bool)) {
+	files := make(chan string, 256)
+	results := make(chan []GrepResultStruct, 256)
+
+	go func() {
+		walkFiles(ctx, grep.root, "", IgnoreStruct{}, files)
+		close(files)
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < runtime.NumCPU(); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for rel := range files {
+				if ctx.Err() != nil {
+					continue // drain
+				}
+				results <- grepFile(grep.root, rel, re)
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	// collect results and send them in batches
+	go func() {
+		var batch []GrepResultStruct
+		count := 0
+		ticker := time.NewTicker(grepBatchDelay)
+		defer ticker.Stop()
+		post := func(done bool) {
+			b, c := batch, count
+			batch, count = nil, 0
+			screen.PostEventWait(tcell.NewEventInterrupt(func() {
+				if ctx.Err() == nil {
+					deliver(b, c, done)
+				}
+			}))
+		}
+		for {
+			select {
+			case r, ok := <-results:
+				if !ok {
+					post(true)
+					return
+				}
+				batch = append(batch, r...)
+				count++
+			case <-ticker.C:
+				if count > 0 {
+					post(false)
+				}
+			}
+		}
+	}()
+}
+
+// startGrep opens the results document and starts the search in the
+// current working directory
+func (ed *EditorStruct) startGrep(pattern string, isRegexp bool) error {
+	expr := pattern
+	if !isRegexp {
+		expr = regexp.QuoteMeta(pattern)
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return err
+	}
+	root, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+
+	// reuse the results document of a previous search
+	var doc *DocStruct
+	for _, d := range ed.docs {
+		if d.grep != nil {
+			doc = d
+			d.grep.cancel()
+		}
+	}
+	if doc == nil {
+		doc = newDoc("*grep*")
+		doc.readonly = true
+		doc.scratch = true
+		ed.addDoc(doc)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	grep := &GrepStruct{pattern: pattern, regexp: isRegexp, root: root, cancel: cancel}
+	doc.grep = grep
+	doc.SetText(buffer.NewPieceText(LineType(grep.summary())))
+	doc.absolutCursor = CursorStruct{}
+	doc.viewport = xyStruct{}
+	doc.topRow = 0
+	ed.show(doc)
+
+	grep.run(ctx, re, doc.screen.Screen, func(results []GrepResultStruct, files int, done bool) {
+		for _, r := range results {
+			doc.InsertLine(nil, doc.Len(), LineType(fmt.Sprintf("%s:%d:%d: %s", r.path, r.line, r.column, r.text)))
+		}
+		grep.locations = append(grep.locations, results...)
+		grep.files += files
+		grep.done = done
+		// the windows of the results are redrawn after the event
+		doc.SetLine(nil, 0, LineType(grep.summary()))
+	})
+	return nil
+}
+
+func (grep *GrepStruct) summary() string {
+	kind := "text"
+	if grep.regexp {
+		kind = "regexp"
+	}
+	state := "searching..."
+	if grep.done {
+		state = "done, Enter opens a match"
+	}
+	return fmt.Sprintf("grep %s %q in %s: %d matches in %d files, %s",
+		kind, grep.pattern, grep.root, len(grep.locations), grep.files, state)
+}
+
+// handleEventGrepOpen opens the file of the match under the cursor
+func (doc *DocStruct) handleEventGrepOpen() {
+	row := doc.absolutCursor.y - 1
+	if row < 0 || row >= len(doc.grep.locations) {
+		return
+	}
+	location := doc.grep.locations[row]
+	target, err := editor.openDoc(filepath.Join(doc.grep.root, filepath.FromSlash(location.path)))
+	if err != nil {
+		doc.setError("%v", err)
+		return
+	}
+	target.gotoPosition(location.line, location.column)
+	target.renderScreen()
+}
+
+// handleEventGrep asks for the text to search in all files below the
+// working directory, Ctrl-T switches between text and regexp
+func (doc *DocStruct) handleEventGrep() {
+	isRegexp := false
+	label := func() string {
+		if isRegexp {
+			return "grep regexp: "
+		}
+		return "grep text: "
+	}
+	prompt := &PromptStruct{label: label()}
+	prompt.onKey = func(event *tcell.EventKey) bool {
+		if event.Key() != tcell.KeyCtrlT {
+			return false
+		}
+		isRegexp = !isRegexp
+		prompt.label = label()
+		return true
+	}
+	prompt.onEnter = func(input string) {
+		if input == "" {
+			return
+		}
+		if err := editor.startGrep(input, isRegexp); err != nil {
+			doc.setError("%v", err)
+		}
+	}
+	doc.openPrompt(prompt)
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// The gutter left of the text shows line numbers, counted from the start of
+// the document or from the cursor line. It grows with the number of lines,
+// so the text starts at the column gutterWidth.
+
+const minLineNumberDigits = 3
+
+type LineNumbersType int
+
+const (
+	LineNumbersOff LineNumbersType = iota
+	LineNumbersAbsolute
+	LineNumbersRelative
+)
+
+func (ln LineNumbersType) String() string {
+	switch ln {
+	case LineNumbersAbsolute:
+		return "on"
+	case LineNumbersRelative:
+		return "relative"
+	default:
+		return "off"
+	}
+}
+
+func parseLineNumbers(s string) (LineNumbersType, bool) {
+	switch strings.ToLower(s) {
+	case "off":
+		return LineNumbersOff, true
+	case "on", "absolute":
+		return LineNumbersAbsolute, true
+	case "relative":
+		return LineNumbersRelative, true
+	}
+	return LineNumbersOff, false
+}
+
+// GutterStruct describes the rendered gutter, when it differs from the
+// current one the screen is rendered again
+type GutterStruct struct {
+	lineNumbers LineNumbersType
+	width       int
+	cursorY     int // line of the cursor, relative numbers count from it
+}
+
+// gutterWidth returns the number of columns left of the text
+func (doc *DocStruct) gutterWidth() int {
+	if doc.lineNumbers == LineNumbersOff {
+		return 0
+	}
+	digits := len(strconv.Itoa(doc.Len()))
+	if digits < minLineNumberDigits {
+		digits = minLineNumberDigits
+	}
+	return digits + 1 // space between number and text
+}
+
+func (doc *DocStruct) currentGutter() GutterStruct {
+	if doc.lineNumbers == LineNumbersOff {
+		return GutterStruct{}
+	}
+	return GutterStruct{lineNumbers: doc.lineNumbers, width: doc.gutterWidth(), cursorY: doc.absolutCursor.y}
+}
+
+// renderLineNumber draws the number of line y in screen row
+func (doc *DocStruct) renderLineNumber(row, y int) {
+	width := doc.gutterWidth()
+	if width == 0 {
+		return
+	}
+	n := y + 1
+	style := doc.screen.lineNumberStyle
+	if y == doc.absolutCursor.y {
+		style = doc.screen.currentLineNumberStyle
+	} else if doc.lineNumbers == LineNumbersRelative {
+		n = y - doc.absolutCursor.y
+		if n < 0 {
+			n = -n
+		}
+	}
+	doc.renderString(0, row, fmt.Sprintf("%*d ", width-1, n), style)
+}
+
+// updateGutter renders the screen again if the gutter became wider or the
+// cursor moved to another line
+func (doc *DocStruct) updateGutter() {
+	if doc.gutter != doc.currentGutter() {
+		doc.renderScreen()
+	}
+}
@@ -0,0 +1,409 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"embed"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"jostermeier.de/edit/buffer"
+)
+
+// Syntax highlighting splits a line into tokens with the regular expressions
+// of a language definition. Every definition is a state machine: a match
+// can switch to another state with other rules, like in a block comment.
+// The state at the start of each line is cached, so a change only
+// highlights the lines behind it again until a line starts in the same
+// state as before.
+
+//go:embed syntax/*.syntax
+var builtinSyntaxFiles embed.FS
+
+type SyntaxRuleStruct struct {
+	re    *regexp.Regexp
+	class string
+	next  int // state after a match, -1 to stay
+}
+
+type SyntaxStateStruct struct {
+	name  string
+	class string // of text no rule matches
+	rules []SyntaxRuleStruct
+}
+
+// SyntaxStruct is the definition of a language, state 0 is "default"
+type SyntaxStruct struct {
+	name      string
+	files     []string       // globs matched against the file name
+	firstLine *regexp.Regexp // matched against the first line, like #!/bin/sh
+	indent    string         // "tabs" or "spaces" inserted by the tab key, empty for the config
+	states    []SyntaxStateStruct
+}
+
+var syntaxes []*SyntaxStruct
+
+func (syn *SyntaxStruct) state(name string) int {
+	for i, st := range syn.states {
+		if st.name == name {
+			return i
+		}
+	}
+	syn.states = append(syn.states, SyntaxStateStruct{name: name})
+	return len(syn.states) - 1
+}
+
+// parseSyntax reads a language definition, the format is described in the
+// built-in definitions
+func parseSyntax(name string, data []byte) (*SyntaxStruct, error) {
+	syn := &SyntaxStruct{states: []SyntaxStateStruct{{name: "default"}}}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, found := cut(line, "=")
+		if !found {
+			return nil, fmt.Errorf("%s:%d: missing '=' in %q", name, lineNumber, line)
+		}
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		fields := strings.Fields(value)
+		switch key {
+		case "name":
+			syn.name = value
+		case "files":
+			syn.files = fields
+		case "firstline":
+			re, err := regexp.Compile(value)
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: %v", name, lineNumber, err)
+			}
+			syn.firstLine = re
+		case "indent":
+			if value != "tabs" && value != "spaces" {
+				return nil, fmt.Errorf("%s:%d: expected indent = tabs or spaces", name, lineNumber)
+			}
+			syn.indent = value
+		case "state":
+			if len(fields) != 2 {
+				return nil, fmt.Errorf("%s:%d: expected state = STATE CLASS", name, lineNumber)
+			}
+			syn.states[syn.state(fields[0])].class = fields[1]
+		case "rule":
+			if len(fields) < 3 {
+				return nil, fmt.Errorf("%s:%d: expected rule = STATE CLASS REGEXP [=> NEXT]", name, lineNumber)
+			}
+			state, class := fields[0], fields[1]
+			// the regexp is the rest of the value, it may contain spaces
+			expr := strings.TrimSpace(value[len(state):])
+			expr = strings.TrimSpace(expr[len(class):])
+			next := -1
+			if i := strings.LastIndex(expr, " => "); i >= 0 && len(strings.Fields(expr[i+4:])) == 1 {
+				next = syn.state(strings.TrimSpace(expr[i+4:]))
+				expr = strings.TrimSpace(expr[:i])
+			}
+			re, err := regexp.Compile(expr)
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: %v", name, lineNumber, err)
+			}
+			st := syn.state(state)
+			syn.states[st].rules = append(syn.states[st].rules, SyntaxRuleStruct{re: re, class: class, next: next})
+		default:
+			return nil, fmt.Errorf("%s:%d: unknown key %q", name, lineNumber, key)
+		}
+	}
+	if syn.name == "" {
+		return nil, fmt.Errorf("%s: missing name", name)
+	}
+	return syn, scanner.Err()
+}
+
+// loadSyntaxes reads the built-in language definitions and the files in
+// dir, which replace built-in definitions of the same name
+func loadSyntaxes(dir string) error {
+	byName := map[string]*SyntaxStruct{}
+	builtin, _ := builtinSyntaxFiles.ReadDir("syntax")
+	for _, entry := range builtin {
+		data, err := builtinSyntaxFiles.ReadFile(path.Join("syntax", entry.Name()))
+		if err != nil {
+			return err
+		}
+		syn, err := parseSyntax(entry.Name(), data)
+		if err != nil {
+			return err
+		}
+		byName[syn.name] = syn
+	}
+
+	var errs []string
+	if dir != "" {
+		names, _ := filepath.Glob(filepath.Join(dir, "*.syntax"))
+		for _, name := range names {
+			data, err := os.ReadFile(name)
+			if err == nil {
+				var syn *SyntaxStruct
+				if syn, err = parseSyntax(name, data); err == nil {
+					byName[syn.name] = syn
+					continue
+				}
+			}
+			errs = append(errs, err.Error())
+		}
+	}
+
+	syntaxes = syntaxes[:0]
+	for _, syn := range byName {
+		syntaxes = append(syntaxes, syn)
+	}
+	sort.Slice(syntaxes, func(i, j int) bool { return syntaxes[i].name < syntaxes[j].name })
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+func lookupSyntax(name string) *SyntaxStruct {
+	for _, syn := range syntaxes {
+		if syn.name == name {
+			return syn
+		}
+	}
+	return nil
+}
+
+// detectSyntax returns the language of a file by its name or first line,
+// nil if none matches
+func detectSyntax(filename string, firstLine LineType) *SyntaxStruct {
+	base := filepath.Base(filename)
+	for _, syn := range syntaxes {
+		for _, glob := range syn.files {
+			if ok, _ := filepath.Match(glob, base); ok {
+				return syn
+			}
+		}
+	}
+	for _, syn := range syntaxes {
+		if syn.firstLine != nil && syn.firstLine.MatchString(string(firstLine)) {
+			return syn
+		}
+	}
+	return nil
+}
+
+// tokenCacheStruct holds the matches of a rule in the line being tokenized
+type tokenCacheStruct struct {
+	matches [][]int
+	index   int
+}
+
+// nextMatch returns the first match of rule at or behind pos. The matches
+// are searched in the whole line, so ^ and \b see the text before pos.
+func (rule *SyntaxRuleStruct) nextMatch(s string, pos int, cache map[*SyntaxRuleStruct]*tokenCacheStruct) []int {
+	c := cache[rule]
+	if c == nil {
+		c = &tokenCacheStruct{matches: rule.re.FindAllStringIndex(s, -1)}
+		cache[rule] = c
+	}
+	for c.index < len(c.matches) && c.matches[c.index][0] < pos {
+		if c.matches[c.index][1] > pos {
+			// overlaps the token before, search again behind it
+			m := rule.re.FindStringIndex(s[pos:])
+			if m == nil {
+				return nil
+			}
+			return []int{m[0] + pos, m[1] + pos}
+		}
+		c.index++
+	}
+	if c.index == len(c.matches) {
+		return nil
+	}
+	return c.matches[c.index]
+}
+
+// tokenize returns the class of every rune of line starting in state, and
+// the state at the end of the line
+func (syn *SyntaxStruct) tokenize(line LineType, state int) ([]string, int) {
+	s := string(line)
+	byteClasses := make([]string, len(s))
+	fill := func(from, to int, class string) {
+		for i := from; i < to; i++ {
+			byteClasses[i] = class
+		}
+	}
+	cache := map[*SyntaxRuleStruct]*tokenCacheStruct{}
+	pos := 0
+	// empty matches which switch the state could alternate forever
+	for steps := 0; steps <= 2*len(s)+8; steps++ {
+		st := &syn.states[state]
+		var best []int
+		var bestRule *SyntaxRuleStruct
+		for i := range st.rules {
+			rule := &st.rules[i]
+			m := rule.nextMatch(s, pos, cache)
+			if m == nil || (m[0] == m[1] && rule.next < 0) {
+				continue
+			}
+			if best == nil || m[0] < best[0] {
+				best, bestRule = m, rule
+			}
+		}
+		if best == nil {
+			fill(pos, len(s), st.class)
+			break
+		}
+		fill(pos, best[0], st.class)
+		fill(best[0], best[1], bestRule.class)
+		pos = best[1]
+		if bestRule.next >= 0 {
+			state = bestRule.next
+		}
+	}
+
+	classes := make([]string, 0, len(line))
+	for i := range s {
+		classes = append(classes, byteClasses[i])
+	}
+	return classes, state
+}
+
+// HighlightStruct caches the state at the start of every line of a buffer
+type HighlightStruct struct {
+	syntax     *SyntaxStruct
+	states     []int
+	valid      int // states[:valid] are correct
+	known      int // states[:known] were correct before the latest changes
+	changedEnd int // lines at and behind changedEnd weren't changed
+}
+
+func newHighlight(syn *SyntaxStruct) *HighlightStruct {
+	if syn == nil {
+		return nil
+	}
+	return &HighlightStruct{syntax: syn}
+}
+
+// invalidate is called when the whole text was replaced
+func (h *HighlightStruct) invalidate() {
+	if h != nil {
+		h.states = nil
+	}
+}
+
+func (h *HighlightStruct) reset(lines int) {
+	h.states = make([]int, lines)
+	h.valid, h.known, h.changedEnd = 1, 1, 0
+}
+
+// changed is called when line row was changed
+func (h *HighlightStruct) changed(row int) {
+	if h == nil || row >= len(h.states) {
+		return
+	}
+	if h.valid > row+1 {
+		h.valid = row + 1
+	}
+	if h.changedEnd < row+1 {
+		h.changedEnd = row + 1
+	}
+}
+
+// inserted is called when a line was inserted at row
+func (h *HighlightStruct) inserted(row int) {
+	if h == nil || len(h.states) == 0 || row > len(h.states) {
+		return
+	}
+	if row == len(h.states) {
+		// starts in the state at the end of the line before, unknown yet
+		h.states = append(h.states, 0)
+		if h.valid > row {
+			h.valid = row
+		}
+	} else {
+		// starts in the state the line which moved down started in
+		h.states = append(h.states[:row+1], h.states[row:]...)
+		if h.valid > row+1 {
+			h.valid = row + 1
+		}
+		if h.known > row {
+			h.known++
+		}
+		if h.changedEnd > row {
+			h.changedEnd++
+		}
+	}
+	if h.changedEnd < row+1 {
+		h.changedEnd = row + 1
+	}
+}
+
+// deleted is called when line row was deleted
+func (h *HighlightStruct) deleted(row int) {
+	if h == nil || row >= len(h.states) {
+		return
+	}
+	h.states = append(h.states[:row], h.states[row+1:]...)
+	if h.valid > row {
+		h.valid = row
+	}
+	if h.valid < 1 {
+		h.valid = 1
+	}
+	if h.known > row {
+		h.known--
+	}
+	if h.changedEnd > row {
+		h.changedEnd--
+	}
+	if h.changedEnd < row {
+		h.changedEnd = row
+	}
+	if len(h.states) > 0 {
+		h.states[0] = 0
+	}
+}
+
+// update makes the start states correct up to line y
+func (h *HighlightStruct) update(text buffer.Text, y int) {
+	if len(h.states) != text.Len() {
+		// the whole text was replaced
+		h.reset(text.Len())
+	}
+	for h.valid <= y && h.valid < text.Len() {
+		i := h.valid - 1
+		_, end := h.syntax.tokenize(text.Line(i), h.states[i])
+		if i+1 >= h.changedEnd && i+1 < h.known && h.states[i+1] == end {
+			// the following lines start in the same state as before
+			h.valid = h.known
+			continue
+		}
+		h.states[i+1] = end
+		h.valid++
+		if h.known < h.valid {
+			h.known = h.valid
+		}
+	}
+	if h.valid >= h.changedEnd {
+		h.changedEnd = 0
+	}
+}
+
+// lineClasses returns the token class of every rune of line y, nil if the
+// document isn't highlighted
+func (doc *DocStruct) lineClasses(y int) []string {
+	h := doc.highlight
+	if h == nil {
+		return nil
+	}
+	h.update(doc.Text(), y)
+	classes, _ := h.syntax.tokenize(doc.Line(y), h.states[y])
+	return classes
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseSyntax(t *testing.T) {
+	if err := loadSyntaxes(t.TempDir()); err != nil {
+		t.Fatalf("Error %v", err)
+	}
+	for _, test := range []struct {
+		filename, firstLine, want string
+	}{
+		{"edit.go", "", "go"},
+		{"README.md", "", "markdown"},
+		{"run", "#!/bin/sh", "shell"},
+		{"notes.txt", "", ""},
+	} {
+		syn := detectSyntax(test.filename, LineType(test.firstLine))
+		name := ""
+		if syn != nil {
+			name = syn.name
+		}
+		if name != test.want {
+			t.Errorf("syntax of %s is %q, expected %q", test.filename, name, test.want)
+		}
+	}
+
+	_, err := parseSyntax("test", []byte("name = test\nrule = default string \"(\n"))
+	if err == nil || !strings.HasPrefix(err.Error(), "test:2:") {
+		t.Errorf("expected error in line 2, got %v", err)
+	}
+	syn, err := parseSyntax("test", []byte("name = test\nrule = default comment # => a => comment\n"))
+	if err != nil {
+		t.Fatalf("Error %v", err)
+	}
+	rule := syn.states[0].rules[0]
+	if rule.re.String() != "# => a" || syn.states[rule.next].name != "comment" {
+		t.Errorf("rule parsed as %q => %d", rule.re, rule.next)
+	}
+}
+
+func TestTokenize(t *testing.T) {
+	if err := loadSyntaxes(""); err != nil {
+		t.Fatalf("Error %v", err)
+	}
+	syn := lookupSyntax("go")
+	classes, state := syn.tokenize(LineType(`x := "a" /* b`), 0)
+	want := []string{"", "", "", "", "", "string", "string", "string", "", "comment", "comment", "comment", "comment"}
+	if strings.Join(classes, ",") != strings.Join(want, ",") {
+		t.Errorf("classes %q, expected %q", classes, want)
+	}
+	if syn.states[state].name != "comment" {
+		t.Errorf("state at the end is %s, expected comment", syn.states[state].name)
+	}
+	classes, state = syn.tokenize(LineType("c */ if"), state)
+	if classes[0] != "comment" || classes[5] != "keyword" || state != 0 {
+		t.Errorf("classes %q, state %d", classes, state)
+	}
+}
+
+func TestSyntaxIndent(t *testing.T) {
+	if err := loadSyntaxes(""); err != nil {
+		t.Fatalf("Error %v", err)
+	}
+	doc := newTestDoc(t, "x")
+	editor = EditorStruct{screen: doc.screen, docs: []*DocStruct{doc}}
+	editor.initWindows()
+	editor.show(doc)
+
+	// setting the syntax also sets what the tab key inserts
+	if err := doc.executeCommand("syntax go"); err != nil || doc.expandTabs {
+		t.Errorf("Error %v, expand tabs %v with go", err, doc.expandTabs)
+	}
+	if err := doc.executeCommand("syntax off"); err != nil || !doc.expandTabs {
+		t.Errorf("Error %v, expand tabs %v without syntax", err, doc.expandTabs)
+	}
+}
+
+func TestHighlightChanges(t *testing.T) {
+	if err := loadSyntaxes(""); err != nil {
+		t.Fatalf("Error %v", err)
+	}
+	lines := make([]string, 100)
+	for i := range lines {
+		lines[i] = "x := 1"
+	}
+	doc := newTestDoc(t, lines...)
+	doc.highlight = newHighlight(lookupSyntax("go"))
+	h := doc.highlight
+	if classes := doc.lineClasses(99); classes[5] != "number" {
+		t.Errorf("classes %q", classes)
+	}
+
+	// a change which doesn't affect the following lines
+	doc.absolutCursor = CursorStruct{x: 0, y: 10}
+	typeString(doc, "y")
+	h.update(doc.Text(), 11)
+	if h.valid != doc.Len() {
+		t.Errorf("%d lines valid after change in line 10, expected all", h.valid)
+	}
+
+	// starting a comment changes all following lines
+	before := doc.Undo.Current
+	doc.absolutCursor = CursorStruct{x: 0, y: 20}
+	typeString(doc, "/*")
+	if classes := doc.lineClasses(99); classes[5] != "comment" {
+		t.Errorf("classes %q after comment start", classes)
+	}
+	doc.gotoUndoState(before)
+	if classes := doc.lineClasses(99); classes[5] != "number" {
+		t.Errorf("classes %q after undo", classes)
+	}
+
+	// lines inserted and deleted
+	doc.absolutCursor = CursorStruct{x: 0, y: 50}
+	typeString(doc, "/*\n\n*/")
+	if classes := doc.lineClasses(51); len(classes) != 0 {
+		t.Errorf("classes %q of empty line", classes)
+	}
+	if classes := doc.lineClasses(52); classes[0] != "comment" || classes[3] != "" {
+		t.Errorf("classes %q of the line closing the comment", classes)
+	}
+	doc.gotoUndoState(before)
+	for y := 0; y < doc.Len(); y++ {
+		if classes := doc.lineClasses(y); classes[len(classes)-1] != "number" {
+			t.Fatalf("classes %q in line %d after undo", classes, y)
+		}
+	}
+}
@@ -1,40 +1,207 @@
 package main
 
 import (
-	"bufio"
+	"crypto/sha256"
+	"io"
 	"os"
+	"path/filepath"
+	"runtime"
+
+	"jostermeier.de/edit/buffer"
 )
 
-func (doc *DocStruct) handleEventLoad() error {
-	// open file and read line by line
-	f, err := os.Open(doc.filename)
+// mapThreshold is the size from which files are mapped into memory instead
+// of being read
+const mapThreshold = 64 << 20
+
+// mappingStruct is a file mapped into memory, it is unmapped when it isn't
+// used anymore
+type mappingStruct struct {
+	data []byte
+	info os.FileInfo // of the file when it was mapped
+}
+
+// readFileData returns the content of a file, a large file is mapped. The
+// mapping is shared with the file: when another program writes the file in
+// place instead of replacing it, the lines which weren't edited change with
+// it and their old content is lost, also for undo.
+func readFileData(filename string) ([]byte, *mappingStruct, error) {
+	f, err := os.Open(filename)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 	defer f.Close()
-	doc.text = make([]LineType, 0, 256)
-	scanner := bufio.NewScanner(f) // default delimiter is new line
-	for scanner.Scan() {
-		doc.text = append(doc.text, LineType(scanner.Text()))
+	info, err := f.Stat()
+	if err != nil {
+		return nil, nil, err
+	}
+	if size := info.Size(); info.Mode().IsRegular() && size >= mapThreshold && size == int64(int(size)) {
+		if data, err := mapFile(f, int(size)); err == nil {
+			mapping := &mappingStruct{data: data, info: info}
+			runtime.SetFinalizer(mapping, func(m *mappingStruct) { unmapFile(m.data) })
+			return data, mapping, nil
+		}
 	}
-	if err := scanner.Err(); err != nil {
+	data, err := io.ReadAll(f)
+	return data, nil, err
+}
+
+// readFile reads the file of the document, the lines are decoded when they
+// are used
+func (doc *DocStruct) readFile() (*buffer.PieceText, FileFormatStruct, [sha256.Size]byte, error) {
+	data, mapping, err := readFileData(doc.filename)
+	if err != nil {
+		return nil, FileFormatStruct{}, [sha256.Size]byte{}, err
+	}
+	defer runtime.KeepAlive(mapping)
+	encoding := detectEncoding(data)
+	if doc.encodingName != "" {
+		encoding, err = lookupEncoding(doc.encodingName)
+		if err != nil {
+			return nil, FileFormatStruct{}, [sha256.Size]byte{}, err
+		}
+	}
+	text, format := encoding.decodeText(data, mapping)
+	return text, format, sha256.Sum256(data), nil
+}
+
+func (doc *DocStruct) handleEventLoad() error {
+	text, format, hash, err := doc.readFile()
+	if err != nil {
 		return err
 	}
+	doc.SetText(text)
+	doc.format = format
+	doc.savedFormat = doc.format
+	doc.savedHash = hash
+	doc.diskInfo, _ = os.Stat(doc.filename)
 
 	return nil
 }
 
 func (doc *DocStruct) handleEventSave() error {
-	f, err := os.Create("data.txt")
+	data, err := doc.format.encoding.encode(doc.Text(), doc.format)
 	if err != nil {
 		return err
 	}
-	defer f.Close()
-	for _, line := range doc.text {
-		_, err = f.WriteString(string(line))
-		if err != nil {
-			return err
+	if err := writeFileAtomic(doc.filename, data); err != nil {
+		return err
+	}
+	if pt, ok := doc.Text().(*buffer.PieceText); ok && pt.Mapped() {
+		// the mapped file may have been overwritten in place, refer to the
+		// new one
+		text, _ := doc.format.encoding.decodeText(data, nil)
+		if mapped, mapping, err := readFileData(doc.filename); err == nil {
+			text, _ = doc.format.encoding.decodeText(mapped, mapping)
 		}
+		doc.SetText(text)
+	}
+	doc.savedHash = sha256.Sum256(data)
+	doc.diskInfo, _ = os.Stat(doc.filename)
+	doc.savedFormat = doc.format
+	doc.Undo.Saved = doc.Undo.Current
+	// the swap file refers to the saved content now, the changes before
+	// are in it
+	doc.swap.pending = nil
+	doc.swap.reset = true
+	return nil
+}
+
+// handleEventSaveWithStatus saves and shows the result in the status line,
+// it returns false if saving failed
+func (doc *DocStruct) handleEventSaveWithStatus() bool {
+	if err := doc.handleEventSave(); err != nil {
+		doc.setError("error saving %s: %v", doc.filename, err)
+		return false
+	}
+	if err := doc.saveUndoHistory(); err != nil {
+		doc.setError("saved %s, but not the undo history: %v", doc.filename, err)
+	} else {
+		doc.setStatus("saved %s (%d lines)", doc.filename, doc.Len())
+	}
+	return true
+}
+
+// modified reports whether the document was changed since it was loaded or
+// saved. Undoing back to the saved state makes it unmodified again.
+func (doc *DocStruct) modified() bool {
+	return doc.Undo.Current != doc.Undo.Saved || doc.format != doc.savedFormat
+}
+
+// writeFileAtomic replaces the file by writing a temporary file in the same
+// directory and renaming it over the original, so the file is never left
+// half written. Permissions and ownership of an existing file are kept.
+func writeFileAtomic(filename string, data []byte) error {
+	// write through symbolic links instead of replacing them
+	if target, err := filepath.EvalSymlinks(filename); err == nil {
+		filename = target
+	}
+
+	info, err := os.Stat(filename)
+	if os.IsNotExist(err) {
+		// nothing to protect, create the file directly (honoring umask)
+		return writeFileInPlace(filename, data)
+	} else if err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(filename)
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(filename)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tmpName := tmp.Name()
+	defer os.Remove(tmpName) // no-op after successful rename
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmpName, info.Mode().Perm()); err != nil {
+		return err
 	}
+	if err := copyOwner(tmpName, info); err != nil {
+		// the new file can't get the owner of the original, e.g. when
+		// editing another user's file, so overwrite the original in place
+		return writeFileInPlace(filename, data)
+	}
+	if err := os.Rename(tmpName, filename); err != nil {
+		return err
+	}
+	syncDir(dir)
 	return nil
 }
+
+func writeFileInPlace(filename string, data []byte) error {
+	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// syncDir flushes the directory entry after a rename, errors are ignored
+// because not every platform supports syncing directories
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	d.Sync()
+	d.Close()
+}
@@ -0,0 +1,386 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gdamore/tcell/v2"
+	"jostermeier.de/edit/buffer"
+)
+
+func TestWriteFileAtomic(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "test.txt")
+
+	// new file
+	if err := writeFileAtomic(filename, []byte("one\n")); err != nil {
+		t.Fatalf("Error %v", err)
+	}
+	if err := os.Chmod(filename, 0640); err != nil {
+		t.Fatalf("Error %v", err)
+	}
+
+	// replace existing file
+	if err := writeFileAtomic(filename, []byte("two\n")); err != nil {
+		t.Fatalf("Error %v", err)
+	}
+	data, err := os.ReadFile(filename)
+	if err != nil || string(data) != "two\n" {
+		t.Fatalf("Error %q %v", data, err)
+	}
+	info, err := os.Stat(filename)
+	if err != nil || info.Mode().Perm() != 0640 {
+		t.Fatalf("Error permissions %v %v", info.Mode(), err)
+	}
+
+	// no temporary files left behind
+	entries, _ := os.ReadDir(filepath.Dir(filename))
+	if len(entries) != 1 {
+		t.Fatalf("Error %d files", len(entries))
+	}
+}
+
+func TestSaveNewline(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "test.txt")
+	if err := os.WriteFile(filename, []byte("a\r\nb\r\n"), 0644); err != nil {
+		t.Fatalf("Error %v", err)
+	}
+	doc := DocStruct{BufferStruct: newBuffer(filename)}
+	if err := doc.handleEventLoad(); err != nil {
+		t.Fatalf("Error %v", err)
+	}
+	if doc.Len() != 2 || string(doc.Line(1)) != "b" || doc.format.lineEnding != LineEndingCRLF {
+		t.Fatalf("Error %q %v", buffer.AllLines(doc.Text()), doc.format)
+	}
+	doc.SetLine(nil, 1, LineType("c"))
+	if err := doc.handleEventSave(); err != nil {
+		t.Fatalf("Error %v", err)
+	}
+	data, _ := os.ReadFile(filename)
+	if string(data) != "a\r\nc\r\n" {
+		t.Fatalf("Error %q", data)
+	}
+}
+
+func TestDecodeEncode(t *testing.T) {
+	for _, data := range []string{
+		"",
+		"\n",
+		"a",
+		"a\nb\n",
+		"a\nb",
+		"a\r\nb\r\n",
+		"a\rb\r",
+		"\xEF\xBB\xBFa\nb\n",
+		"\n\n\n",
+		"invalid \xff\xfe utf-8 \xe4\n",
+		"\xFF\xFEa\x00\n\x00",
+		"\xFF\xFEa\x00\x00\xD8b\x00\n\x00\x3D\xD8\x00\xDE\n",
+		"\xFE\xFF\xDC\x00\x00\n\x00",
+		"\xFF\xFEa\x00\n\x00b",
+	} {
+		encoding := detectEncoding([]byte(data))
+		lines, format := encoding.decode([]byte(data))
+		result, err := encoding.encode(buffer.NewPieceText(lines...), format)
+		if err != nil || string(result) != data {
+			t.Fatalf("Error %q became %q %v", data, result, err)
+		}
+	}
+
+	lines, format := utf8Encoding.decode([]byte("a\r\nb\nc\r\n"))
+	if len(lines) != 3 || !format.mixed || format.lineEnding != LineEndingCRLF || !format.finalNewline {
+		t.Fatalf("Error %q %v", lines, format)
+	}
+	// with mixed line endings every line keeps its own
+	for _, data := range []string{"a\r\nb\nc\rd\n\r\n", "\xFF\xFEa\x00\r\x00\n\x00b\x00\n\x00"} {
+		encoding := detectEncoding([]byte(data))
+		text, format := encoding.decodeText([]byte(data), nil)
+		if result, err := encoding.encode(text, format); err != nil || string(result) != data {
+			t.Fatalf("Error %q became %q %v", data, result, err)
+		}
+	}
+	// changed and new lines get the most frequent line ending, converting
+	// changes all
+	mixed, mixedFormat := utf8Encoding.decodeText([]byte("a\r\nb\nc\r\nd\r"), nil)
+	mixed.SetLine(1, LineType("B"))
+	mixed.InsertLines(3, LineType("new"))
+	if result, _ := utf8Encoding.encode(mixed, mixedFormat); string(result) != "a\r\nB\r\nc\r\nnew\r\nd\r" {
+		t.Fatalf("Error %q", result)
+	}
+	mixedFormat.mixed = false
+	if result, _ := utf8Encoding.encode(mixed, mixedFormat); string(result) != "a\r\nB\r\nc\r\nnew\r\nd\r\n" {
+		t.Fatalf("Error %q", result)
+	}
+	lines, format = utf8Encoding.decode([]byte("\xEF\xBB\xBFa"))
+	if len(lines) != 1 || string(lines[0]) != "a" || !format.bom || format.finalNewline {
+		t.Fatalf("Error %q %v", lines, format)
+	}
+
+	// utf-16 with byte order mark
+	lines, format = detectEncoding([]byte("\xFF\xFEa\x00")).decode([]byte("\xFF\xFEa\x00"))
+	if len(lines) != 1 || string(lines[0]) != "a" || !format.bom || format.encoding.name != "utf-16le" {
+		t.Fatalf("Error %q %v", lines, format)
+	}
+
+	// a lone surrogate keeps its bytes, a pair is one character
+	lines, _ = detectEncoding([]byte("\xFF\xFEa\x00\x00\xD8\x3D\xD8\x00\xDE")).decode([]byte("\xFF\xFEa\x00\x00\xD8\x3D\xD8\x00\xDE"))
+	if len(lines) != 1 || string(lines[0]) != "a\uFFFD\uFFFD😀" || !isRawByte(lines[0][1]) || !isRawByte(lines[0][2]) {
+		t.Fatalf("Error %q", lines)
+	}
+
+	// windows-1252, 0x81 is undefined
+	encoding, _ := lookupEncoding("cp1252")
+	lines, format = encoding.decode([]byte("\x80\xe4\x81"))
+	if len(lines) != 1 || string(lines[0][:2]) != "€ä" || !isRawByte(lines[0][2]) {
+		t.Fatalf("Error %q %v", lines, format)
+	}
+	result, err := encoding.encode(buffer.NewPieceText(lines...), format)
+	if err != nil || string(result) != "\x80\xe4\x81" {
+		t.Fatalf("Error %q %v", result, err)
+	}
+	encoding, _ = lookupEncoding("latin-1")
+	if _, err := encoding.encode(buffer.NewPieceText(LineType("€")), format); err == nil {
+		t.Fatalf("Error")
+	}
+}
+
+func TestLoadLongLine(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "test.txt")
+	long := strings.Repeat("x", 200*1024)
+	if err := os.WriteFile(filename, []byte(long+"\n"), 0644); err != nil {
+		t.Fatalf("Error %v", err)
+	}
+	doc := DocStruct{BufferStruct: newBuffer(filename)}
+	if err := doc.handleEventLoad(); err != nil {
+		t.Fatalf("Error %v", err)
+	}
+	if doc.Len() != 1 || len(doc.Line(0)) != len(long) {
+		t.Fatalf("Error %d lines", doc.Len())
+	}
+}
+
+func TestSwapFile(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	editor.screen = newTestDoc(t).screen
+	filename := filepath.Join(t.TempDir(), "test.txt")
+	if err := os.WriteFile(filename, []byte("a\n"), 0644); err != nil {
+		t.Fatalf("Error %v", err)
+	}
+	doc, err := loadDoc(filename, "", false)
+	if err != nil {
+		t.Fatalf("Error %v", err)
+	}
+	if err := doc.writeSwap(); err != nil || doc.swap.written {
+		t.Fatalf("swap file of an unchanged document written, %v", err)
+	}
+	typeString(doc, "x")
+	if err := doc.writeSwap(); err != nil {
+		t.Fatalf("Error %v", err)
+	}
+	// later changes are appended, a record cut off by a crash is ignored
+	first, _ := os.ReadFile(doc.swap.name)
+	typeString(doc, "y")
+	if err := doc.writeSwap(); err != nil {
+		t.Fatalf("Error %v", err)
+	}
+	data, _ := os.ReadFile(doc.swap.name)
+	if len(data) <= len(first) || string(data[:len(first)]) != string(first) {
+		t.Errorf("swap file rewritten")
+	}
+	if err := os.WriteFile(doc.swap.name, append(data, 100), 0600); err != nil {
+		t.Fatalf("Error %v", err)
+	}
+
+	// the swap file of a crashed session is offered for recovery
+	crashed, err := loadDoc(filename, "", false)
+	if err != nil {
+		t.Fatalf("Error %v", err)
+	}
+	if crashed.swap.stale == nil || docText(crashed) != "a" {
+		t.Fatalf("stale swap file not found")
+	}
+	file, recovered, err := crashed.swapText(crashed.swap.stale)
+	if err != nil {
+		t.Fatalf("Error %v", err)
+	}
+	diff := unifiedDiff("file", "swap", file, recovered)
+	if len(diff) != 5 || string(diff[3]) != "-a" || string(diff[4]) != "+xya" {
+		t.Errorf("diff %q", diff)
+	}
+	if err := crashed.recoverSwap(); err != nil {
+		t.Fatalf("Error %v", err)
+	}
+	if docText(crashed) != "xya" || !crashed.modified() {
+		t.Errorf("recovered %q, modified %v", docText(crashed), crashed.modified())
+	}
+	crashed.handleEventUndo()
+	if docText(crashed) != "a" || crashed.modified() {
+		t.Errorf("undo after recovery %q, modified %v", docText(crashed), crashed.modified())
+	}
+
+	// a swap file of a running instance opens the file read only
+	sf, err := readSwapFile(crashed.swap.name)
+	if err != nil {
+		t.Fatalf("Error %v", err)
+	}
+	sf.Pid = os.Getppid()
+	data, err = sf.encode()
+	if err != nil {
+		t.Fatalf("Error %v", err)
+	}
+	if err := os.WriteFile(crashed.swap.name, data, 0600); err != nil {
+		t.Fatalf("Error %v", err)
+	}
+	second, err := loadDoc(filename, "", false)
+	if err != nil {
+		t.Fatalf("Error %v", err)
+	}
+	if !second.readonly || second.swap.name != "" {
+		t.Errorf("second instance should open read only")
+	}
+
+	name := crashed.swap.name
+	crashed.removeSwap()
+	if _, err := os.Stat(name); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("swap file not removed")
+	}
+}
+
+func TestSwapAfterSave(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	editor.screen = newTestDoc(t).screen
+	filename := filepath.Join(t.TempDir(), "test.txt")
+	if err := os.WriteFile(filename, []byte("a\nb\nc\n"), 0644); err != nil {
+		t.Fatalf("Error %v", err)
+	}
+	doc, err := loadDoc(filename, "", false)
+	if err != nil {
+		t.Fatalf("Error %v", err)
+	}
+	// the changes before saving aren't written to the new swap file
+	doc.absolutCursor = CursorStruct{x: 1, y: 0}
+	typeString(doc, "\n")
+	if err := doc.handleEventSave(); err != nil {
+		t.Fatalf("Error %v", err)
+	}
+	doc.absolutCursor = CursorStruct{x: 0, y: 3}
+	typeString(doc, "ZZ")
+	if len(doc.swap.pending) != 1 {
+		t.Errorf("%d changes of one line pending", len(doc.swap.pending))
+	}
+	if err := doc.writeSwap(); err != nil {
+		t.Fatalf("Error %v", err)
+	}
+
+	crashed, err := loadDoc(filename, "", false)
+	if err != nil {
+		t.Fatalf("Error %v", err)
+	}
+	if crashed.swap.stale == nil {
+		t.Fatalf("stale swap file not found")
+	}
+	if err := crashed.recoverSwap(); err != nil {
+		t.Fatalf("Error %v", err)
+	}
+	if docText(crashed) != docText(doc) {
+		t.Errorf("recovered %q, expected %q", docText(crashed), docText(doc))
+	}
+	crashed.removeSwap()
+}
+
+func TestDiffLines(t *testing.T) {
+	lines := func(s string) *buffer.LineSlice {
+		text := buffer.LineSlice{}
+		for _, r := range s {
+			text = append(text, LineType{r})
+		}
+		return &text
+	}
+	textA, textB := lines("abcabba"), lines("cbabac")
+	script := diffLines(textA, textB)
+	var a, b, ops string
+	for _, s := range script {
+		for k := 0; k < s.count; k++ {
+			switch s.op {
+			case diffEqual:
+				a, b, ops = a+string(textA.Line(s.a+k)), b+string(textB.Line(s.b+k)), ops+"="
+			case diffDelete:
+				a, ops = a+string(textA.Line(s.a+k)), ops+"-"
+			case diffInsert:
+				b, ops = b+string(textB.Line(s.b+k)), ops+"+"
+			}
+		}
+	}
+	if a != "abcabba" || b != "cbabac" || strings.Count(ops, "=") != 4 {
+		t.Errorf("script %s gives %q, %q", ops, a, b)
+	}
+
+	// a change of the first line of a large file, the rest is one run
+	long := make(buffer.LineSlice, 100000)
+	for i := range long {
+		long[i] = LineType(fmt.Sprint(i))
+	}
+	changed := append(buffer.LineSlice{LineType("x")}, long[1:]...)
+	script = diffLines(&long, &changed)
+	want := []DiffStruct{{diffDelete, 0, 0, 1}, {diffInsert, 1, 0, 1}, {diffEqual, 1, 1, len(long) - 1}}
+	if fmt.Sprint(script) != fmt.Sprint(want) {
+		t.Errorf("script %v", script)
+	}
+}
+
+func TestReload(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	editor.screen = newTestDoc(t).screen
+	filename := filepath.Join(t.TempDir(), "test.txt")
+	if err := os.WriteFile(filename, []byte("a\nb\nc\n"), 0644); err != nil {
+		t.Fatalf("Error %v", err)
+	}
+	doc, err := loadDoc(filename, "", false)
+	if err != nil {
+		t.Fatalf("Error %v", err)
+	}
+	editor = EditorStruct{screen: editor.screen, docs: []*DocStruct{doc}}
+	editor.initWindows()
+	editor.show(doc)
+	doc.absolutCursor = CursorStruct{x: 1, y: 2, wantX: 1}
+
+	// unmodified documents are reloaded, the cursor stays on its line
+	if err := os.WriteFile(filename, []byte("new\na\nb\nc\n"), 0644); err != nil {
+		t.Fatalf("Error %v", err)
+	}
+	editor.checkFiles()
+	if docText(doc) != "new\na\nb\nc" || doc.absolutCursor.y != 3 || doc.modified() {
+		t.Errorf("reloaded %q, cursor %v, modified %v", docText(doc), doc.absolutCursor, doc.modified())
+	}
+	doc.handleEventUndo()
+	if docText(doc) != "a\nb\nc" {
+		t.Errorf("undo of reload %q", docText(doc))
+	}
+	doc.handleEventRedo()
+
+	// modified documents ask first
+	doc.absolutCursor = CursorStruct{x: 1, y: 3, wantX: 1}
+	typeString(doc, "x")
+	if err := os.WriteFile(filename, []byte("other\n"), 0644); err != nil {
+		t.Fatalf("Error %v", err)
+	}
+	editor.checkFiles()
+	if doc.prompt == nil || docText(doc) != "new\na\nb\ncx" {
+		t.Fatalf("modified document should ask, text %q", docText(doc))
+	}
+	doc.handlePromptKeyEvent(tcell.NewEventKey(tcell.KeyRune, 'k', 0))
+	if !doc.modified() || docText(doc) != "new\na\nb\ncx" {
+		t.Errorf("keep changed the document")
+	}
+	editor.checkFiles()
+	if doc.prompt != nil {
+		t.Errorf("asked again for the same change")
+	}
+	doc.handleEventReload()
+	if docText(doc) != "other" || doc.modified() {
+		t.Errorf("reload %q", docText(doc))
+	}
+}
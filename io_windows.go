@@ -0,0 +1,33 @@
+//go:build windows
+// +build windows
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+// copyOwner does nothing on windows, a renamed file keeps the ACL of the directory
+func copyOwner(name string, info os.FileInfo) error {
+	return nil
+}
+
+// processRunning reports whether a process with pid exists
+func processRunning(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	p.Release()
+	return true
+}
+
+// mapFile isn't supported on windows, files are read
+func mapFile(f *os.File, size int) ([]byte, error) {
+	return nil, errors.New("mapping files isn't supported")
+}
+
+func unmapFile(data []byte) error {
+	return nil
+}
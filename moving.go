@@ -1,21 +1,32 @@
 package main
 
 import (
+	"sort"
 	"unicode"
 
 	"github.com/gdamore/tcell/v2"
 )
 
 func (doc *DocStruct) handleEventCursorDown() {
+	if doc.wrap != WrapOff {
+		doc.moveRows(1)
+		doc.adjustViewport()
+		return
+	}
 	doc.absolutCursor.y++
-	if doc.absolutCursor.y >= len(doc.text) {
-		doc.absolutCursor.y = len(doc.text) - 1
+	if doc.absolutCursor.y >= doc.Len() {
+		doc.absolutCursor.y = doc.Len() - 1
 	}
 	doc.alignCursorX()
 	doc.adjustViewport()
 }
 
 func (doc *DocStruct) handleEventCursorUp() {
+	if doc.wrap != WrapOff {
+		doc.moveRows(-1)
+		doc.adjustViewport()
+		return
+	}
 	doc.absolutCursor.y--
 	if doc.absolutCursor.y < 0 {
 		doc.absolutCursor.y = 0
@@ -25,83 +36,86 @@ func (doc *DocStruct) handleEventCursorUp() {
 }
 
 func (doc *DocStruct) handleEventCursorRight(event *tcell.EventKey) {
-	l := len(doc.text[doc.absolutCursor.y])
+	l := len(doc.Line(doc.absolutCursor.y))
 	// go the right
 	if doc.absolutCursor.x < l {
 		if event.Modifiers()&2 != 0 {
 			// control is pressed - go one word to the right
-			for ; doc.absolutCursor.x < l; doc.absolutCursor.x++ {
-				r := doc.text[doc.absolutCursor.y][doc.absolutCursor.x]
+			line := doc.Line(doc.absolutCursor.y)
+			bounds := clusterBounds(line)
+			i := sort.SearchInts(bounds, doc.absolutCursor.x)
+			for ; i+1 < len(bounds); i++ {
+				r := line[bounds[i]]
 				if unicode.IsLetter(r) || unicode.IsDigit(r) {
 					break
 				}
 			}
-			for ; doc.absolutCursor.x < l; doc.absolutCursor.x++ {
-				r := doc.text[doc.absolutCursor.y][doc.absolutCursor.x]
+			for ; i+1 < len(bounds); i++ {
+				r := line[bounds[i]]
 				if !(unicode.IsLetter(r) || unicode.IsDigit(r)) {
 					break
 				}
 			}
+			doc.absolutCursor.x = bounds[i]
 		} else {
 			// go one character to the right
-			doc.absolutCursor.x++
+			doc.absolutCursor.x = nextCluster(doc.Line(doc.absolutCursor.y), doc.absolutCursor.x)
 		}
 	} else {
 		// if cursor is on last position in line, go to beginning of next line
-		if doc.absolutCursor.y < len(doc.text)-1 {
+		if doc.absolutCursor.y < doc.Len()-1 {
 			doc.absolutCursor.y++
 			doc.absolutCursor.x = 0
 		}
 	}
-	doc.absolutCursor.wantX = doc.absolutCursor.x
-	doc.alignCursorX()
+	doc.updateWantX()
 	doc.adjustViewport()
 }
 
 func (doc *DocStruct) handleEventCursorLeft(event *tcell.EventKey) {
 	if doc.absolutCursor.x > 0 {
+		line := doc.Line(doc.absolutCursor.y)
 		if event.Modifiers()&2 != 0 {
-			doc.absolutCursor.x--
 			// control is pressed - go one word left
-			for ; doc.absolutCursor.x > 0; doc.absolutCursor.x-- {
-				r := doc.text[doc.absolutCursor.y][doc.absolutCursor.x]
+			bounds := clusterBounds(line)
+			i := sort.SearchInts(bounds, doc.absolutCursor.x) - 1
+			for ; i > 0; i-- {
+				r := line[bounds[i]]
 				if !(unicode.IsLetter(r) || unicode.IsDigit(r)) {
 					break
 				}
 			}
-			for ; doc.absolutCursor.x > 0; doc.absolutCursor.x-- {
-				r := doc.text[doc.absolutCursor.y][doc.absolutCursor.x]
+			for ; i > 0; i-- {
+				r := line[bounds[i]]
 				if unicode.IsLetter(r) || unicode.IsDigit(r) {
 					break
 				}
 			}
+			doc.absolutCursor.x = bounds[i]
 		} else {
 			// go one character left
-			doc.absolutCursor.x--
+			doc.absolutCursor.x = prevCluster(line, doc.absolutCursor.x)
 		}
 	} else {
 		// cursor left when cursor is on first position of line
 		if doc.absolutCursor.y > 0 {
 			doc.absolutCursor.y--
-			doc.absolutCursor.x = len(doc.text[doc.absolutCursor.y])
+			doc.absolutCursor.x = len(doc.Line(doc.absolutCursor.y))
 		}
 	}
-	doc.absolutCursor.wantX = doc.absolutCursor.x
-	doc.alignCursorX()
+	doc.updateWantX()
 	doc.adjustViewport()
 }
 
 func (doc *DocStruct) handleEventCursorBeginOfLine() {
 	doc.absolutCursor.x = 0
-	doc.absolutCursor.wantX = doc.absolutCursor.x
-	doc.alignCursorX()
+	doc.updateWantX()
 	doc.adjustViewport()
 }
 
 func (doc *DocStruct) handleEventCursorEndOfLine() {
-	doc.absolutCursor.x = len(doc.text[doc.absolutCursor.y])
-	doc.absolutCursor.wantX = doc.absolutCursor.x
-	doc.alignCursorX()
+	doc.absolutCursor.x = len(doc.Line(doc.absolutCursor.y))
+	doc.updateWantX()
 	doc.adjustViewport()
 }
 
@@ -110,9 +124,15 @@ func (doc *DocStruct) handleEventPageDown() {
 	if maxy > 1 {
 		maxy--
 	}
+	if doc.wrap != WrapOff {
+		// count the rows of wrapped lines
+		doc.moveRows(maxy)
+		doc.adjustViewport()
+		return
+	}
 	doc.absolutCursor.y = doc.absolutCursor.y + maxy
-	if doc.absolutCursor.y >= len(doc.text) {
-		doc.absolutCursor.y = len(doc.text) - 1
+	if doc.absolutCursor.y >= doc.Len() {
+		doc.absolutCursor.y = doc.Len() - 1
 	}
 	doc.alignCursorX()
 	doc.adjustViewport()
@@ -123,6 +143,12 @@ func (doc *DocStruct) handleEventPageUp() {
 	if maxy > 1 {
 		maxy--
 	}
+	if doc.wrap != WrapOff {
+		// count the rows of wrapped lines
+		doc.moveRows(-maxy)
+		doc.adjustViewport()
+		return
+	}
 	doc.absolutCursor.y = doc.absolutCursor.y - maxy
 	if doc.absolutCursor.y < 0 {
 		doc.absolutCursor.y = 0
@@ -131,6 +157,21 @@ func (doc *DocStruct) handleEventPageUp() {
 	doc.adjustViewport()
 }
 
+// gotoPosition places the cursor on the 1-based line and column,
+// values out of range are clamped to the document
+func (doc *DocStruct) gotoPosition(line, column int) {
+	doc.absolutCursor.y = line - 1
+	if doc.absolutCursor.y >= doc.Len() {
+		doc.absolutCursor.y = doc.Len() - 1
+	}
+	if doc.absolutCursor.y < 0 {
+		doc.absolutCursor.y = 0
+	}
+	doc.absolutCursor.x = column - 1
+	doc.updateWantX()
+	doc.adjustViewport()
+}
+
 func (doc *DocStruct) handleKeyEventCursor(event *tcell.EventKey) (handled bool) {
 	doc.renderKeyInfo(event)
 	handled = true
@@ -164,13 +205,18 @@ func (doc *DocStruct) handleKeyEventCursor(event *tcell.EventKey) (handled bool)
 	return
 }
 
+// alignCursorX places the cursor in its line at the column of wantX, in
+// wrap mode in the row of the cursor
 func (doc *DocStruct) alignCursorX() {
-	doc.absolutCursor.x = doc.absolutCursor.wantX
-	len := len(doc.text[doc.absolutCursor.y])
-	if doc.absolutCursor.x > len {
-		doc.absolutCursor.x = len
-	}
-	if doc.absolutCursor.x < 0 {
-		doc.absolutCursor.x = 0
+	line := doc.Line(doc.absolutCursor.y)
+	if doc.wrap != WrapOff {
+		x := doc.absolutCursor.x
+		if x > len(line) {
+			x = len(line)
+		}
+		row, _ := doc.wrapPosition(line, x)
+		doc.absolutCursor.x = doc.wrapIndex(line, row, doc.absolutCursor.wantX)
+		return
 	}
+	doc.absolutCursor.x = doc.runeIndex(line, doc.absolutCursor.wantX)
 }
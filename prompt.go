@@ -0,0 +1,98 @@
+package main
+
+import (
+	"github.com/gdamore/tcell/v2"
+	"github.com/mattn/go-runewidth"
+)
+
+// PromptStruct is a single line input shown in the status line
+type PromptStruct struct {
+	label    string
+	input    LineType
+	cursor   int
+	onKey    func(event *tcell.EventKey) bool // handles additional keys, returns true if handled
+	onChange func(input string)
+	onEnter  func(input string)
+	onCancel func()
+	onRender func() // draws additional content, like a list of choices
+}
+
+// openPrompt shows a prompt in the status line, key events go to the prompt
+// until Enter or Escape is pressed
+func (doc *DocStruct) openPrompt(prompt *PromptStruct) {
+	prompt.cursor = len(prompt.input)
+	doc.prompt = prompt
+	doc.renderStatusLine()
+}
+
+func (doc *DocStruct) closePrompt() {
+	doc.prompt = nil
+	doc.renderStatusLine()
+}
+
+func (doc *DocStruct) handlePromptKeyEvent(event *tcell.EventKey) {
+	prompt := doc.prompt
+	if prompt.onKey != nil && prompt.onKey(event) {
+		doc.renderStatusLine()
+		return
+	}
+	changed := false
+	switch event.Key() {
+	case tcell.KeyEscape, tcell.KeyCtrlC:
+		doc.closePrompt()
+		if prompt.onCancel != nil {
+			prompt.onCancel()
+		}
+		return
+	case tcell.KeyEnter:
+		doc.closePrompt()
+		if prompt.onEnter != nil {
+			prompt.onEnter(string(prompt.input))
+		}
+		return
+	case tcell.KeyRune:
+		prompt.input = concatenateLines(prompt.input[:prompt.cursor], LineType{event.Rune()}, prompt.input[prompt.cursor:])
+		prompt.cursor++
+		changed = true
+	case tcell.KeyBackspace, tcell.KeyBackspace2:
+		if prompt.cursor > 0 {
+			prompt.input = concatenateLines(prompt.input[:prompt.cursor-1], prompt.input[prompt.cursor:])
+			prompt.cursor--
+			changed = true
+		}
+	case tcell.KeyDelete:
+		if prompt.cursor < len(prompt.input) {
+			prompt.input = concatenateLines(prompt.input[:prompt.cursor], prompt.input[prompt.cursor+1:])
+			changed = true
+		}
+	case tcell.KeyLeft:
+		if prompt.cursor > 0 {
+			prompt.cursor--
+		}
+	case tcell.KeyRight:
+		if prompt.cursor < len(prompt.input) {
+			prompt.cursor++
+		}
+	case tcell.KeyHome:
+		prompt.cursor = 0
+	case tcell.KeyEnd:
+		prompt.cursor = len(prompt.input)
+	}
+	doc.renderStatusLine()
+	if changed && prompt.onChange != nil {
+		prompt.onChange(string(prompt.input))
+	}
+}
+
+// renderPrompt draws the prompt and returns the column behind the input
+func (doc *DocStruct) renderPrompt(y int) int {
+	text := doc.prompt.label + string(doc.prompt.input)
+	doc.renderString(0, y, text, doc.screen.statusStyle)
+	return runewidth.StringWidth(text)
+}
+
+func (doc *DocStruct) showPromptCursor() {
+	_, maxy := doc.screen.Size()
+	x := runewidth.StringWidth(doc.prompt.label + string(doc.prompt.input[:doc.prompt.cursor]))
+	doc.screen.ShowCursor(x, maxy-1)
+}
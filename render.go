@@ -9,57 +9,105 @@ import (
 )
 
 func (doc *DocStruct) showCursor() {
+	if doc.prompt != nil {
+		doc.showPromptCursor()
+		return
+	}
+	doc.updateGutter()
 	doc.screen.ShowCursor(
-		doc.absolutCursor.x-doc.viewport.x,
-		doc.absolutCursor.y-doc.viewport.y,
+		doc.gutterWidth()+doc.cursorColumn()-doc.viewport.x,
+		doc.cursorRow(),
 	)
 }
 
+// clusterCell returns how the grapheme cluster of width w is drawn: the
+// rune and combining runes of its cells and the number of cells to set
+func clusterCell(cluster LineType, w int) (r rune, comb []rune, cells int) {
+	r = cluster[0]
+	if len(cluster) > 1 {
+		comb = cluster[1:]
+	}
+	cells = 1
+	if r == '\t' {
+		// spaces up to the next tab stop
+		r = ' '
+		cells = w
+	} else if isRawByte(r) {
+		// byte which is invalid in the encoding of the file
+		r = utf8.RuneError
+	} else if runewidth.RuneWidth(r) == 0 {
+		comb = cluster
+		r = ' '
+	}
+	return r, comb, cells
+}
+
+// runeStyle returns the style of the rune at xy, matches and classes are
+// those of its line
+func (doc *DocStruct) runeStyle(xy xyStruct, matches []bool, classes []string) tcell.Style {
+	if xy.in(doc.selection) {
+		return doc.screen.selectionStyle
+	} else if matches != nil && matches[xy.x] {
+		return doc.screen.searchStyle
+	} else if classes != nil && classes[xy.x] != "" {
+		return doc.screen.syntaxStyle(classes[xy.x])
+	}
+	return doc.screen.defaultStyle
+}
+
 func (doc *DocStruct) renderLine(row int) {
-	style := doc.screen.defaultStyle
+	if doc.wrap != WrapOff {
+		doc.renderWrappedLines()
+		doc.renderInfoLine()
+		return
+	}
 	maxx, maxy := doc.screen.Size()
-	xyRelative := xyStruct{x: -doc.viewport.x, y: row}
+	gutter := doc.gutterWidth()
+	xyRelative := xyStruct{x: gutter - doc.viewport.x, y: row}
 	if xyRelative.y < 0 {
 		xyRelative.y = 0
 	}
-	if xyRelative.y >= maxy {
-		xyRelative.y = maxy - 1
+	if xyRelative.y >= maxy-1 {
+		// last row is reserved for the status line
+		xyRelative.y = maxy - 2
 	}
 	xyAbsolute := xyStruct{x: 0, y: doc.viewport.y + row}
+	doc.renderLineNumber(xyRelative.y, xyAbsolute.y)
 
-	// iterate runes of line
-	for _, r := range doc.text[xyAbsolute.y] {
-		var comb []rune
-		w := runewidth.RuneWidth(r)
-		if w == 0 {
-			comb = []rune{r}
-			r = ' '
-			w = 1
-		}
-		if xyRelative.x >= 0 {
-			if xyAbsolute.in(doc.selection) {
-				style = doc.screen.selectionStyle
-			} else {
-				style = doc.screen.defaultStyle
+	matches := doc.searchMatches(doc.Line(xyAbsolute.y))
+	classes := doc.lineClasses(xyAbsolute.y)
+
+	// iterate grapheme clusters of line, combining characters are drawn
+	// in the cell of their base character
+	line := doc.Line(xyAbsolute.y)
+	bounds := clusterBounds(line)
+	for i := 0; i+1 < len(bounds); i++ {
+		xyAbsolute.x = bounds[i]
+		cluster := line[bounds[i]:bounds[i+1]]
+		w := doc.clusterWidth(cluster, xyRelative.x-gutter+doc.viewport.x)
+		r, comb, cells := clusterCell(cluster, w)
+		style := doc.runeStyle(xyAbsolute, matches, classes)
+		for i := 0; i < cells; i++ {
+			if xyRelative.x+i >= gutter {
+				doc.screen.SetContent(xyRelative.x+i, xyRelative.y, r, comb, style)
 			}
-			doc.screen.SetContent(xyRelative.x, xyRelative.y, r, comb, style)
 		}
 		xyRelative.x += w
-		xyAbsolute.x++
+		xyAbsolute.x = bounds[i+1]
 		if xyRelative.x >= maxx {
 			break
 		}
 	}
 
 	// mark the first character if an empty line is part of selection
-	if xyRelative.x == 0 && xyAbsolute.in(doc.selection) {
-		doc.screen.SetContent(0, xyRelative.y, ' ', nil, doc.screen.selectionStyle)
+	if xyRelative.x == gutter && xyAbsolute.in(doc.selection) {
+		doc.screen.SetContent(gutter, xyRelative.y, ' ', nil, doc.screen.selectionStyle)
 		xyRelative.x++
 	}
 
 	// clear rest of line
-	if xyRelative.x < 0 {
-		xyRelative.x = 0
+	if xyRelative.x < gutter {
+		xyRelative.x = gutter
 	}
 	for ; xyRelative.x < maxx-1; xyRelative.x++ {
 		doc.screen.SetContent(xyRelative.x, xyRelative.y, ' ', nil, doc.screen.defaultStyle)
@@ -68,22 +116,122 @@ func (doc *DocStruct) renderLine(row int) {
 
 func (doc *DocStruct) renderScreen() {
 	doc.screen.Clear()
+	doc.gutter = doc.currentGutter()
+	if doc.wrap != WrapOff {
+		doc.renderWrappedLines()
+	} else {
+		_, maxy := doc.screen.Size()
+		for y := 0; y < maxy-1; y++ {
+			if doc.Len() <= doc.viewport.y+y {
+				break
+			}
+			doc.renderLine(y)
+		}
+	}
+	doc.renderInfoLine()
+	doc.renderStatusLine()
+}
+
+// redrawStruct collects the changes of a buffer since its windows were
+// rendered, the windows are redrawn after every event
+type redrawStruct struct {
+	from, to int  // changed lines, half-open, none if from == to
+	all      bool // lines were inserted or deleted, the lines below moved
+}
+
+func (r *redrawStruct) line(y int) {
+	if r.from == r.to {
+		r.from, r.to = y, y+1
+	} else if y < r.from {
+		r.from = y
+	} else if y >= r.to {
+		r.to = y + 1
+	}
+}
+
+// renderChanges redraws what the changes of the buffer since the last event
+// require
+func (doc *DocStruct) renderChanges() {
+	r := doc.redraw
+	if r.all {
+		doc.renderScreen()
+		return
+	}
+	if r.from == r.to {
+		return
+	}
+	if doc.wrap != WrapOff {
+		// a changed line can move the lines below it
+		doc.renderLine(0)
+		return
+	}
 	_, maxy := doc.screen.Size()
-	for y := 0; y < maxy; y++ {
-		if len(doc.text) <= doc.viewport.y+y {
-			break
+	for y := r.from; y < r.to; y++ {
+		if row := y - doc.viewport.y; row >= 0 && row < maxy-1 {
+			doc.renderLine(row)
 		}
-		doc.renderLine(y)
 	}
 	doc.renderInfoLine()
 }
 
+// setStatus shows a message in the status line until the next key is pressed
+func (doc *DocStruct) setStatus(format string, a ...interface{}) {
+	doc.statusMessage = fmt.Sprintf(format, a...)
+	doc.statusIsError = false
+	doc.renderStatusLine()
+}
+
+// setError shows an error in the status line until the next key is pressed
+func (doc *DocStruct) setError(format string, a ...interface{}) {
+	doc.statusMessage = fmt.Sprintf(format, a...)
+	doc.statusIsError = true
+	doc.renderStatusLine()
+}
+
+func (doc *DocStruct) clearStatus() {
+	if doc.statusMessage == "" {
+		return
+	}
+	doc.statusMessage = ""
+	doc.renderStatusLine()
+}
+
+func (doc *DocStruct) renderStatusLine() {
+	maxx, maxy := doc.screen.Size()
+	y := maxy - 1
+	for x := 0; x < maxx; x++ {
+		doc.screen.SetContent(x, y, ' ', nil, doc.screen.statusStyle)
+	}
+	if doc.prompt != nil {
+		x := doc.renderPrompt(y)
+		// messages like search results are shown behind the input
+		if doc.statusMessage != "" {
+			style := doc.screen.statusStyle
+			if doc.statusIsError {
+				style = doc.screen.errorStyle
+			}
+			doc.renderString(x+2, y, doc.statusMessage, style)
+		}
+		if doc.prompt.onRender != nil {
+			doc.prompt.onRender()
+		}
+		return
+	}
+	style := doc.screen.statusStyle
+	if doc.statusIsError {
+		style = doc.screen.errorStyle
+	}
+	doc.renderString(0, y, doc.statusMessage, style)
+}
+
 func (doc *DocStruct) renderInfoLine() {
-	line := fmt.Sprintf("C:%d,%d | P:%d,%d | Ss:%d,%d | Se:%d,%d",
+	line := fmt.Sprintf("C:%d,%d | P:%d,%d | Ss:%d,%d | Se:%d,%d | %s | %s",
 		doc.absolutCursor.x, doc.absolutCursor.y,
 		doc.previousCursor.x, doc.previousCursor.y,
 		doc.selection.begin.x, doc.selection.begin.y,
 		doc.selection.end.x, doc.selection.end.y,
+		doc.format,
+		doc.docName(),
 	)
 
 	maxx, _ := doc.screen.Size()
@@ -140,37 +288,46 @@ func (doc *DocStruct) renderString(x, y int, s string, style tcell.Style) {
 
 func (doc *DocStruct) mustAdjustViewport() bool {
 	screenMaxX, screenMaxY := doc.screen.Size()
+	screenMaxX -= doc.gutterWidth()
+	cursorX := doc.cursorColumn()
 	if doc.absolutCursor.y-doc.viewport.y >= (screenMaxY - 1) {
 		return true
 	}
 	if doc.absolutCursor.y-doc.viewport.y < 0 {
 		return true
 	}
-	if doc.absolutCursor.x-doc.viewport.x >= (screenMaxX - 1) {
+	if cursorX-doc.viewport.x >= (screenMaxX - 1) {
 		return true
 	}
-	if doc.absolutCursor.x-doc.viewport.x < 0 {
+	if cursorX-doc.viewport.x < 0 {
 		return true
 	}
 	return false
 }
 
 func (doc *DocStruct) adjustViewport() {
+	if doc.wrap != WrapOff {
+		doc.adjustWrappedViewport()
+		return
+	}
 	screenMaxX, screenMaxY := doc.screen.Size()
+	screenMaxX -= doc.gutterWidth()
+	cursorX := doc.cursorColumn()
 	if doc.absolutCursor.y-doc.viewport.y >= (screenMaxY - 1) {
-		doc.viewport.y = doc.absolutCursor.y - (screenMaxY - 1)
+		// the last row is the status line
+		doc.viewport.y = doc.absolutCursor.y - (screenMaxY - 2)
 		doc.renderScreen()
 	}
 	if doc.absolutCursor.y-doc.viewport.y < 0 {
 		doc.viewport.y = doc.absolutCursor.y
 		doc.renderScreen()
 	}
-	if doc.absolutCursor.x-doc.viewport.x >= (screenMaxX - 1) {
-		doc.viewport.x = doc.absolutCursor.x - (screenMaxX - 1)
+	if cursorX-doc.viewport.x >= (screenMaxX - 1) {
+		doc.viewport.x = cursorX - (screenMaxX - 1)
 		doc.renderScreen()
 	}
-	if doc.absolutCursor.x-doc.viewport.x < 0 {
-		doc.viewport.x = doc.absolutCursor.x
+	if cursorX-doc.viewport.x < 0 {
+		doc.viewport.x = cursorX
 		doc.renderScreen()
 	}
 }
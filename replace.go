@@ -0,0 +1,243 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"unicode/utf8"
+
+	"github.com/gdamore/tcell/v2"
+	"jostermeier.de/edit/buffer"
+)
+
+// ReplaceStruct is the state of a replace command, which goes through the
+// matches of a regular expression in the selection or the whole document
+type ReplaceStruct struct {
+	re       *regexp.Regexp
+	template string      // replacement, $1 or ${name} insert capture groups
+	from, to xyStruct    // scope as half-open range, to moves when text is replaced
+	pos      xyStruct    // where the search for the next match starts
+	ui       buffer.Edit // all replacements are undone at once
+	count    int         // number of replacements done
+	skipped  int
+}
+
+// MatchStruct is a match of a regular expression in a line
+type MatchStruct struct {
+	y          int
+	begin, end int // rune positions, end exclusive
+	submatches []int
+	s          string // the line the byte positions of submatches refer to
+}
+
+// runeIndex converts byte offsets in s to rune offsets
+func runeIndex(s string, byteOffset int) int {
+	return utf8.RuneCountInString(s[:byteOffset])
+}
+
+// nextMatch finds the next match at or behind pos which lies completely in the scope
+func (rs *ReplaceStruct) nextMatch(text buffer.Text) (MatchStruct, bool) {
+	for y := rs.pos.y; y <= rs.to.y && y < text.Len(); y++ {
+		line := text.Line(y)
+		start := 0
+		if y == rs.pos.y {
+			start = rs.pos.x
+		}
+		end := len(line)
+		if y == rs.to.y {
+			end = rs.to.x
+		}
+		if start > end {
+			continue
+		}
+		// match the whole line, so ^ and \b see the text in front of start
+		s := string(line)
+		byteStart := len(string(line[:start]))
+		byteEnd := len(string(line[:end]))
+		var loc []int
+		for _, l := range rs.re.FindAllStringSubmatchIndex(s, -1) {
+			if l[0] >= byteStart && l[1] <= byteEnd {
+				loc = l
+				break
+			}
+		}
+		if loc == nil {
+			continue
+		}
+		return MatchStruct{
+			y:          y,
+			begin:      runeIndex(s, loc[0]),
+			end:        runeIndex(s, loc[1]),
+			submatches: loc,
+			s:          s,
+		}, true
+	}
+	return MatchStruct{}, false
+}
+
+// skip continues the search behind match
+func (rs *ReplaceStruct) skip(match MatchStruct) {
+	rs.pos = xyStruct{x: match.end, y: match.y}
+	if match.begin == match.end {
+		// don't find the same empty match again
+		rs.pos.x++
+	}
+}
+
+// replace replaces match with the expanded template
+func (rs *ReplaceStruct) replace(doc *DocStruct, match MatchStruct) {
+	replacement := LineType(string(rs.re.ExpandString(nil, rs.template, match.s, match.submatches)))
+	line := doc.Line(match.y)
+	doc.absolutCursor.x = match.begin
+	doc.updateLine(&rs.ui, match.y, concatenateLines(line[:match.begin], replacement, line[match.end:]))
+	if match.y == rs.to.y {
+		rs.to.x += len(replacement) - (match.end - match.begin)
+	}
+	rs.count++
+	rs.pos = xyStruct{x: match.begin + len(replacement), y: match.y}
+	if match.begin == match.end {
+		rs.pos.x++
+	}
+}
+
+// newReplace prepares a replace in the selection, or the whole document if
+// nothing is selected
+func (doc *DocStruct) newReplace(pattern, template string) (*ReplaceStruct, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	rs := &ReplaceStruct{
+		re:       re,
+		template: template,
+	}
+	if doc.selection != emptySelection {
+		rs.from, rs.to = doc.selectionRange()
+	} else {
+		last := doc.Len() - 1
+		rs.to = xyStruct{x: len(doc.Line(last)), y: last}
+	}
+	rs.pos = rs.from
+	return rs, nil
+}
+
+// countMatches counts the matches without replacing anything
+func (doc *DocStruct) countMatches(pattern string) (int, error) {
+	rs, err := doc.newReplace(pattern, "")
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for match, ok := rs.nextMatch(doc.Text()); ok; match, ok = rs.nextMatch(doc.Text()) {
+		count++
+		rs.skip(match)
+	}
+	return count, nil
+}
+
+// replaceAll replaces all remaining matches
+func (rs *ReplaceStruct) replaceAll(doc *DocStruct) {
+	for match, ok := rs.nextMatch(doc.Text()); ok; match, ok = rs.nextMatch(doc.Text()) {
+		rs.replace(doc, match)
+	}
+}
+
+// finishReplace records the replacements as one undo item
+func (doc *DocStruct) finishReplace(rs *ReplaceStruct) {
+	doc.Undo.Push(rs.ui)
+	doc.selection = emptySelection
+	doc.updateWantX()
+	doc.adjustViewport()
+	doc.renderScreen()
+	doc.setStatus("%d replaced, %d skipped", rs.count, rs.skipped)
+}
+
+// confirmReplace shows the next match and asks whether to replace it
+func (doc *DocStruct) confirmReplace(rs *ReplaceStruct) {
+	match, ok := rs.nextMatch(doc.Text())
+	if !ok {
+		doc.finishReplace(rs)
+		return
+	}
+	// show match as selection
+	doc.absolutCursor.x = match.begin
+	doc.absolutCursor.y = match.y
+	doc.updateWantX()
+	doc.selection = selectionStruct{
+		begin: xyStruct{x: match.begin, y: match.y},
+		end:   xyStruct{x: match.end - 1, y: match.y},
+	}
+	doc.adjustViewport()
+	doc.renderScreen()
+
+	doc.openPrompt(&PromptStruct{
+		label: "replace? (y)es (n)o (a)ll (q)uit",
+		onKey: func(event *tcell.EventKey) bool {
+			if event.Key() == tcell.KeyEscape || event.Key() == tcell.KeyCtrlC {
+				doc.closePrompt()
+				doc.finishReplace(rs)
+				return true
+			}
+			if event.Key() != tcell.KeyRune {
+				return true
+			}
+			switch event.Rune() {
+			case 'y':
+				doc.closePrompt()
+				rs.replace(doc, match)
+				doc.confirmReplace(rs)
+			case 'n':
+				doc.closePrompt()
+				rs.skipped++
+				rs.skip(match)
+				doc.confirmReplace(rs)
+			case 'a':
+				doc.closePrompt()
+				rs.replaceAll(doc)
+				doc.finishReplace(rs)
+			case 'q':
+				doc.closePrompt()
+				doc.finishReplace(rs)
+			}
+			return true
+		},
+	})
+}
+
+// handleEventReplace asks for a regular expression and the replacement,
+// then asks for every match whether it should be replaced
+func (doc *DocStruct) handleEventReplace() {
+	doc.openPrompt(&PromptStruct{
+		label: "replace regexp: ",
+		onEnter: func(pattern string) {
+			if _, err := regexp.Compile(pattern); err != nil {
+				doc.setError("%v", err)
+				return
+			}
+			doc.openPrompt(&PromptStruct{
+				label: fmt.Sprintf("replace %s with: ", pattern),
+				onEnter: func(template string) {
+					rs, err := doc.newReplace(pattern, template)
+					if err != nil {
+						doc.setError("%v", err)
+						return
+					}
+					doc.confirmReplace(rs)
+				},
+			})
+		},
+	})
+}
+
+func (doc *DocStruct) handleEventCountMatches() {
+	doc.openPrompt(&PromptStruct{
+		label: "count regexp: ",
+		onEnter: func(pattern string) {
+			count, err := doc.countMatches(pattern)
+			if err != nil {
+				doc.setError("%v", err)
+				return
+			}
+			doc.setStatus("%d matches", count)
+		},
+	})
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"unicode"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+type SearchStruct struct {
+	pattern        LineType
+	caseSensitive  bool
+	highlight      bool         // highlight all matches on screen
+	originCursor   CursorStruct // cursor and viewport restored when the search is canceled
+	originViewport xyStruct
+}
+
+// matchAt reports whether pattern is found in line at position x
+func matchAt(line, pattern LineType, x int, caseSensitive bool) bool {
+	if x < 0 || x+len(pattern) > len(line) {
+		return false
+	}
+	for i, p := range pattern {
+		r := line[x+i]
+		if r != p && (caseSensitive || unicode.ToLower(r) != unicode.ToLower(p)) {
+			return false
+		}
+	}
+	return true
+}
+
+// findInLine returns the first position >= x where pattern is found, or -1
+func findInLine(line, pattern LineType, x int, caseSensitive bool) int {
+	for ; x+len(pattern) <= len(line); x++ {
+		if matchAt(line, pattern, x, caseSensitive) {
+			return x
+		}
+	}
+	return -1
+}
+
+// findInLineBackward returns the last position <= x where pattern is found, or -1
+func findInLineBackward(line, pattern LineType, x int, caseSensitive bool) int {
+	if x > len(line)-len(pattern) {
+		x = len(line) - len(pattern)
+	}
+	for ; x >= 0; x-- {
+		if matchAt(line, pattern, x, caseSensitive) {
+			return x
+		}
+	}
+	return -1
+}
+
+// find searches the pattern starting at from, forward or backward, and
+// continues at the other end of the document. wrapped is true if the match
+// was found after continuing at the other end.
+func (doc *DocStruct) find(from xyStruct, forward bool) (match xyStruct, wrapped, found bool) {
+	pattern := doc.search.pattern
+	if len(pattern) == 0 {
+		return from, false, false
+	}
+	n := doc.Len()
+	for i := 0; i <= n; i++ {
+		y := from.y
+		if forward {
+			y += i
+		} else {
+			y -= i
+		}
+		wrapped = y < 0 || y >= n
+		y = (y%n + n) % n
+		x := -1
+		if forward {
+			start := 0
+			if i == 0 {
+				start = from.x
+			}
+			x = findInLine(doc.Line(y), pattern, start, doc.search.caseSensitive)
+		} else {
+			start := len(doc.Line(y))
+			if i == 0 {
+				start = from.x
+			}
+			x = findInLineBackward(doc.Line(y), pattern, start, doc.search.caseSensitive)
+		}
+		if x >= 0 {
+			return xyStruct{x: x, y: y}, wrapped, true
+		}
+	}
+	return from, false, false
+}
+
+// searchMatches marks the runes of line which are part of a match
+func (doc *DocStruct) searchMatches(line LineType) []bool {
+	pattern := doc.search.pattern
+	if !doc.search.highlight || len(pattern) == 0 {
+		return nil
+	}
+	var marks []bool
+	for x := findInLine(line, pattern, 0, doc.search.caseSensitive); x >= 0; x = findInLine(line, pattern, x+1, doc.search.caseSensitive) {
+		if marks == nil {
+			marks = make([]bool, len(line))
+		}
+		for i := x; i < x+len(pattern); i++ {
+			marks[i] = true
+		}
+	}
+	return marks
+}
+
+// gotoMatch searches from position from and moves the cursor to the match
+func (doc *DocStruct) gotoMatch(from xyStruct, forward bool) {
+	match, wrapped, found := doc.find(from, forward)
+	if !found {
+		doc.absolutCursor = doc.search.originCursor
+		doc.adjustViewport()
+		doc.renderScreen()
+		if len(doc.search.pattern) > 0 {
+			doc.setError("not found: %s", string(doc.search.pattern))
+		}
+		return
+	}
+	doc.absolutCursor.x = match.x
+	doc.absolutCursor.y = match.y
+	doc.updateWantX()
+	doc.adjustViewport()
+	doc.renderScreen()
+	if wrapped {
+		if forward {
+			doc.setStatus("search hit bottom, continued at top")
+		} else {
+			doc.setStatus("search hit top, continued at bottom")
+		}
+	}
+}
+
+func (doc *DocStruct) searchLabel() string {
+	if doc.search.caseSensitive {
+		return "search (case sensitive): "
+	}
+	return "search: "
+}
+
+// handleEventSearch opens the search prompt. The cursor moves to the next
+// match while typing, Up and Down go to the previous and next match, Ctrl-T
+// toggles case sensitivity, Enter keeps the cursor and Escape restores it.
+func (doc *DocStruct) handleEventSearch() {
+	doc.search.originCursor = doc.absolutCursor
+	doc.search.originViewport = doc.viewport
+	doc.search.highlight = true
+	doc.selection = emptySelection
+	origin := xyStruct{x: doc.absolutCursor.x, y: doc.absolutCursor.y}
+
+	prompt := &PromptStruct{
+		label: doc.searchLabel(),
+		input: concatenateLines(doc.search.pattern),
+	}
+	prompt.onChange = func(input string) {
+		doc.search.pattern = LineType(input)
+		doc.gotoMatch(origin, true)
+	}
+	prompt.onKey = func(event *tcell.EventKey) bool {
+		cursor := xyStruct{x: doc.absolutCursor.x, y: doc.absolutCursor.y}
+		switch event.Key() {
+		case tcell.KeyDown, tcell.KeyCtrlN:
+			doc.gotoMatch(xyStruct{x: cursor.x + 1, y: cursor.y}, true)
+		case tcell.KeyUp, tcell.KeyCtrlP:
+			doc.gotoMatch(xyStruct{x: cursor.x - 1, y: cursor.y}, false)
+		case tcell.KeyCtrlT:
+			doc.search.caseSensitive = !doc.search.caseSensitive
+			prompt.label = doc.searchLabel()
+			doc.gotoMatch(origin, true)
+		default:
+			return false
+		}
+		return true
+	}
+	prompt.onCancel = func() {
+		doc.search.highlight = false
+		doc.absolutCursor = doc.search.originCursor
+		doc.viewport = doc.search.originViewport
+		doc.renderScreen()
+	}
+	doc.openPrompt(prompt)
+	if len(prompt.input) > 0 {
+		doc.gotoMatch(origin, true)
+	}
+}
+
+// handleEventSearchNext goes to the next or previous match of the last search
+func (doc *DocStruct) handleEventSearchNext(forward bool) {
+	if len(doc.search.pattern) == 0 {
+		doc.setStatus("no previous search, press Ctrl-F")
+		return
+	}
+	doc.search.originCursor = doc.absolutCursor
+	doc.search.highlight = true
+	x := doc.absolutCursor.x + 1
+	if !forward {
+		x = doc.absolutCursor.x - 1
+	}
+	doc.gotoMatch(xyStruct{x: x, y: doc.absolutCursor.y}, forward)
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"testing"
+)
+
+func TestFind(t *testing.T) {
+	doc := newTestDoc(t, "Foo bar", "baz foo", "")
+	doc.search.pattern = LineType("foo")
+
+	match, wrapped, found := doc.find(xyStruct{x: 0, y: 0}, true)
+	if !found || wrapped || match != (xyStruct{x: 0, y: 0}) {
+		t.Fatalf("Error %v %v %v", match, wrapped, found)
+	}
+	match, wrapped, found = doc.find(xyStruct{x: 1, y: 0}, true)
+	if !found || wrapped || match != (xyStruct{x: 4, y: 1}) {
+		t.Fatalf("Error %v %v %v", match, wrapped, found)
+	}
+	// wrap around at the end of the document
+	match, wrapped, found = doc.find(xyStruct{x: 5, y: 1}, true)
+	if !found || !wrapped || match != (xyStruct{x: 0, y: 0}) {
+		t.Fatalf("Error %v %v %v", match, wrapped, found)
+	}
+	match, wrapped, found = doc.find(xyStruct{x: -1, y: 0}, false)
+	if !found || !wrapped || match != (xyStruct{x: 4, y: 1}) {
+		t.Fatalf("Error %v %v %v", match, wrapped, found)
+	}
+
+	doc.search.caseSensitive = true
+	match, wrapped, found = doc.find(xyStruct{x: 0, y: 0}, true)
+	if !found || match != (xyStruct{x: 4, y: 1}) {
+		t.Fatalf("Error %v %v %v", match, wrapped, found)
+	}
+	doc.search.pattern = LineType("qux")
+	if _, _, found = doc.find(xyStruct{x: 0, y: 0}, true); found {
+		t.Fatalf("Error")
+	}
+}
+
+func TestSearchMatches(t *testing.T) {
+	doc := newTestDoc(t)
+	doc.search = SearchStruct{pattern: LineType("aa"), highlight: true}
+	marks := doc.searchMatches(LineType("xaaaxaa"))
+	expected := []bool{false, true, true, true, false, true, true}
+	for i := range expected {
+		if marks[i] != expected[i] {
+			t.Fatalf("Error %v", marks)
+		}
+	}
+}
+
+func TestReplace(t *testing.T) {
+	doc := newTestDoc(t, "a1 b22", "c333 ä4")
+	count, err := doc.countMatches(`\d+`)
+	if err != nil || count != 4 {
+		t.Fatalf("Error %d %v", count, err)
+	}
+
+	rs, err := doc.newReplace(`(\pL)(\d+)`, "<$2$1>")
+	if err != nil {
+		t.Fatalf("Error %v", err)
+	}
+	match, _ := rs.nextMatch(doc.Text())
+	rs.replace(doc, match)
+	match, _ = rs.nextMatch(doc.Text())
+	rs.skipped++
+	rs.skip(match)
+	rs.replaceAll(doc)
+	doc.finishReplace(rs)
+	if docText(doc) != "<1a> b22\n<333c> <4ä>" || rs.count != 3 || rs.skipped != 1 {
+		t.Fatalf("Error %q %d", docText(doc), rs.count)
+	}
+
+	// all replacements are undone at once
+	doc.handleEventUndo()
+	if docText(doc) != "a1 b22\nc333 ä4" {
+		t.Fatalf("Error %q", docText(doc))
+	}
+
+	// only in the selection
+	doc.selection = selectionStruct{begin: xyStruct{x: 3, y: 0}, end: xyStruct{x: 1, y: 1}}
+	rs, _ = doc.newReplace(`\w`, "x")
+	rs.replaceAll(doc)
+	doc.finishReplace(rs)
+	if docText(doc) != "a1 xxx\nxx33 ä4" {
+		t.Fatalf("Error %q", docText(doc))
+	}
+
+	// anchors see the whole line, not the rest behind the last replacement
+	doc = newTestDoc(t, "aaa", "xx x")
+	for _, r := range [][2]string{{`^a`, "b"}, {`\bx`, "y"}} {
+		rs, _ = doc.newReplace(r[0], r[1])
+		rs.replaceAll(doc)
+		doc.finishReplace(rs)
+	}
+	if docText(doc) != "baa\nyx y" {
+		t.Fatalf("Error %q", docText(doc))
+	}
+
+	// a single replacement after typing is undone on its own
+	doc = newTestDoc(t, "abc")
+	doc.absolutCursor.x = 3
+	typeString(doc, "X")
+	rs, _ = doc.newReplace("b", "B")
+	rs.replaceAll(doc)
+	doc.finishReplace(rs)
+	doc.handleEventUndo()
+	if docText(doc) != "abcX" {
+		t.Fatalf("Error %q", docText(doc))
+	}
+}
+
+func TestGitignore(t *testing.T) {
+	root := t.TempDir()
+	for name, content := range map[string]string{
+		".gitignore":      "*.log\n/build/\n!keep.log\ndocs/**/*.tmp\n",
+		"a.go":            "package a // TODO\n",
+		"a.log":           "TODO\n",
+		"keep.log":        "TODO\n",
+		"build/b.go":      "TODO\n",
+		"sub/build/c.go":  "TODO\n",
+		"sub/.gitignore":  "c.go\n",
+		"sub/d.go":        "x\nTODO\r\n",
+		"docs/x/y/z.tmp":  "TODO\n",
+		"docs/binary.dat": "TODO\x00\n",
+		".git/HEAD":       "TODO\n",
+	} {
+		name = filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatalf("Error %v", err)
+		}
+		if err := os.WriteFile(name, []byte(content), 0644); err != nil {
+			t.Fatalf("Error %v", err)
+		}
+	}
+
+	files := make(chan string, 100)
+	walkFiles(context.Background(), root, "", IgnoreStruct{}, files)
+	close(files)
+	found := []string{}
+	re := regexp.MustCompile("TODO")
+	for rel := range files {
+		for _, result := range grepFile(root, rel, re) {
+			found = append(found, fmt.Sprintf("%s:%d:%d", result.path, result.line, result.column))
+		}
+	}
+	sort.Strings(found)
+	expected := "[a.go:1:14 keep.log:1:1 sub/d.go:2:1]"
+	if fmt.Sprint(found) != expected {
+		t.Fatalf("Error %v", found)
+	}
+}
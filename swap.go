@@ -0,0 +1,476 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+	"jostermeier.de/edit/buffer"
+)
+
+// While a file is edited, a swap file in the state directory records the
+// changes of the text since the file was loaded or saved. New changes are
+// appended every few seconds and the swap file is removed when the document
+// is closed, so a swap file with changes found on open was left by a crash.
+// Recovery replays the changes on the file. The process id in the swap file
+// protects against editing a file in two instances.
+//
+// The swap file is a header followed by the changes, every record is a gob
+// prefixed by its length. A record cut off by a crash is ignored.
+
+const swapFileVersion = 2
+
+type swapHeaderStruct struct {
+	Version int
+	Pid     int
+	Host    string
+	Hash    [sha256.Size]byte // content of the file the changes are based on
+}
+
+// swapRecordStruct is a change reported by the buffer, Line is the new
+// content of a changed line
+type swapRecordStruct struct {
+	Kind buffer.ChangeKind
+	Y    int
+	Line []rune
+}
+
+type swapFileStruct struct {
+	swapHeaderStruct
+	Time    time.Time // of the last write
+	Records []swapRecordStruct
+}
+
+// SwapStruct is the swap file of a buffer
+type SwapStruct struct {
+	name    string             // empty if the buffer has no swap file
+	pending []swapRecordStruct // changes not written yet
+	written bool               // the swap file of this instance exists
+	hash    [sha256.Size]byte  // in the header of the swap file
+	records int                // changes in the swap file
+	reset   bool               // the text is the one of the file again
+	stale   *swapFileStruct    // swap file of a crashed session, not recovered yet
+}
+
+// appendRecord appends v to out as a gob prefixed by its length
+func appendRecord(out *bytes.Buffer, v interface{}) error {
+	var record bytes.Buffer
+	if err := gob.NewEncoder(&record).Encode(v); err != nil {
+		return err
+	}
+	var size [binary.MaxVarintLen64]byte
+	out.Write(size[:binary.PutUvarint(size[:], uint64(record.Len()))])
+	out.Write(record.Bytes())
+	return nil
+}
+
+// nextRecord decodes the record at the start of data into v and returns
+// the data behind it
+func nextRecord(data []byte, v interface{}) ([]byte, error) {
+	size, n := binary.Uvarint(data)
+	if n <= 0 || size > uint64(len(data)-n) {
+		return nil, errors.New("swap file record cut off")
+	}
+	end := n + int(size)
+	return data[end:], gob.NewDecoder(bytes.NewReader(data[n:end])).Decode(v)
+}
+
+func readSwapFile(name string) (*swapFileStruct, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	var sf swapFileStruct
+	rest, err := nextRecord(data, &sf.swapHeaderStruct)
+	if err != nil {
+		return nil, err
+	}
+	if sf.Version != swapFileVersion {
+		return nil, fmt.Errorf("swap file version %d not supported", sf.Version)
+	}
+	for len(rest) > 0 {
+		var record swapRecordStruct
+		if rest, err = nextRecord(rest, &record); err != nil {
+			break // the crash happened while writing
+		}
+		sf.Records = append(sf.Records, record)
+	}
+	if info, err := os.Stat(name); err == nil {
+		sf.Time = info.ModTime()
+	}
+	return &sf, nil
+}
+
+func (sf *swapFileStruct) encode() ([]byte, error) {
+	var out bytes.Buffer
+	if err := appendRecord(&out, sf.swapHeaderStruct); err != nil {
+		return nil, err
+	}
+	for _, record := range sf.Records {
+		if err := appendRecord(&out, record); err != nil {
+			return nil, err
+		}
+	}
+	return out.Bytes(), nil
+}
+
+// running reports whether the instance which wrote the swap file still runs
+func (sf *swapFileStruct) running() bool {
+	host, _ := os.Hostname()
+	return sf.Host == host && sf.Pid != os.Getpid() && processRunning(sf.Pid)
+}
+
+// check reports whether the changes fit a text of n lines
+func (sf *swapFileStruct) check(n int) error {
+	for _, record := range sf.Records {
+		if record.Kind == buffer.LineInserted {
+			n++
+		}
+		if record.Y < 0 || record.Y >= n {
+			return errors.New("the swap file doesn't fit the file")
+		}
+		if record.Kind == buffer.LineDeleted {
+			n--
+		}
+	}
+	return nil
+}
+
+// replay applies the changes to text, the text of the file they are based
+// on. They must have been checked.
+func (sf *swapFileStruct) replay(text buffer.Text) {
+	for _, record := range sf.Records {
+		switch record.Kind {
+		case buffer.LineChanged:
+			text.SetLine(record.Y, append(LineType{}, record.Line...))
+		case buffer.LineInserted:
+			text.InsertLines(record.Y, LineType{})
+		case buffer.LineDeleted:
+			text.DeleteLines(record.Y, record.Y+1)
+		}
+	}
+}
+
+// changed collects the changes of the text for the swap file
+func (swap *SwapStruct) changed(c buffer.Change, text buffer.Text) {
+	if swap.name == "" {
+		return
+	}
+	switch c.Kind {
+	case buffer.LineChanged:
+		if n := len(swap.pending); n > 0 && swap.pending[n-1].Kind == c.Kind && swap.pending[n-1].Y == c.Y {
+			// typing changes the same line again and again, only its last
+			// content is kept
+			swap.pending[n-1].Line = append(swap.pending[n-1].Line[:0], text.Line(c.Y)...)
+			return
+		}
+		line := append([]rune{}, text.Line(c.Y)...)
+		swap.pending = append(swap.pending, swapRecordStruct{Kind: c.Kind, Y: c.Y, Line: line})
+	case buffer.LineInserted, buffer.LineDeleted:
+		swap.pending = append(swap.pending, swapRecordStruct{Kind: c.Kind, Y: c.Y})
+	case buffer.TextReplaced:
+		// the text is replaced when it is read from the file
+		swap.pending = nil
+		swap.reset = true
+	}
+}
+
+// openSwap takes over the swap file of the document. A swap file of another
+// running instance makes the document read only, one with unsaved changes
+// of a crashed session is kept for recovery.
+func (doc *DocStruct) openSwap() error {
+	if doc.readonly || !config.getBool("swap", true) || config.getInt("swap_interval", 4) <= 0 {
+		return nil
+	}
+	name, err := stateFile("swap", doc.filename)
+	if err != nil {
+		return err
+	}
+	doc.swap = SwapStruct{}
+	sf, err := readSwapFile(name)
+	if err == nil && sf.running() {
+		doc.readonly = true
+		return fmt.Errorf("%s is edited by process %d, opened read only", doc.filename, sf.Pid)
+	}
+	doc.swap.name = name
+	if err == nil && len(sf.Records) > 0 {
+		doc.swap.stale = sf
+	}
+	// the swap file is written with the first change, an unchanged buffer
+	// needs none
+	return nil
+}
+
+// writeSwap appends the changes since the last write to the swap file. It
+// starts again when the text is the one of the file.
+func (doc *DocStruct) writeSwap() error {
+	swap := &doc.swap
+	if swap.name == "" || swap.stale != nil {
+		return nil
+	}
+	if doc.Undo.Current == doc.Undo.Saved {
+		swap.pending = nil
+		swap.reset = true
+	}
+	if swap.reset {
+		swap.reset = false
+		if swap.written && (swap.records > 0 || swap.hash != doc.savedHash) {
+			if err := doc.createSwap(nil); err != nil {
+				return err
+			}
+		}
+	}
+	if len(swap.pending) == 0 {
+		return nil
+	}
+	if !swap.written {
+		return doc.createSwap(swap.pending)
+	}
+	var out bytes.Buffer
+	for _, record := range swap.pending {
+		if err := appendRecord(&out, record); err != nil {
+			return err
+		}
+	}
+	f, err := os.OpenFile(swap.name, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(out.Bytes()); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	swap.records += len(swap.pending)
+	swap.pending = nil
+	return nil
+}
+
+// createSwap writes a new swap file with the changes in records
+func (doc *DocStruct) createSwap(records []swapRecordStruct) error {
+	swap := &doc.swap
+	if !swap.written {
+		// another instance may have opened the file after this one
+		if sf, err := readSwapFile(swap.name); err == nil && sf.running() {
+			return fmt.Errorf("%s is edited by process %d too", doc.filename, sf.Pid)
+		}
+	}
+	host, _ := os.Hostname()
+	sf := swapFileStruct{
+		swapHeaderStruct: swapHeaderStruct{
+			Version: swapFileVersion,
+			Pid:     os.Getpid(),
+			Host:    host,
+			Hash:    doc.savedHash,
+		},
+		Records: records,
+	}
+	data, err := sf.encode()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(swap.name), 0700); err != nil {
+		return err
+	}
+	if err := writeFileAtomic(swap.name, data); err != nil {
+		return err
+	}
+	swap.written = true
+	swap.hash = doc.savedHash
+	swap.records = len(records)
+	swap.pending = nil
+	return nil
+}
+
+// removeSwap removes the swap file when the document is closed
+func (doc *DocStruct) removeSwap() {
+	if doc.swap.name != "" && doc.swap.stale == nil {
+		os.Remove(doc.swap.name)
+	}
+	doc.swap.name = ""
+}
+
+// writeSwapFiles writes the swap files of all documents which changed, a
+// document stops using its swap file when writing fails
+func (ed *EditorStruct) writeSwapFiles() {
+	for _, doc := range ed.docs {
+		if err := doc.writeSwap(); err != nil {
+			doc.swap.name = ""
+			ed.doc().setError("swap file of %s disabled: %v", doc.filename, err)
+		}
+	}
+}
+
+// startSwapTimer writes the swap files periodically on the event loop
+func startSwapTimer(screen tcell.Screen) {
+	interval := time.Duration(config.getInt("swap_interval", 4)) * time.Second
+	if interval <= 0 {
+		return
+	}
+	go func() {
+		for range time.Tick(interval) {
+			screen.PostEvent(tcell.NewEventInterrupt(editor.writeSwapFiles))
+		}
+	}()
+}
+
+var errSwapOutdated = errors.New("the file was changed after the swap file was written")
+
+// swapText returns the text of the file and the text after the changes of
+// the swap file sf
+func (doc *DocStruct) swapText(sf *swapFileStruct) (file, recovered buffer.Text, err error) {
+	text, _, hash, err := doc.readFile()
+	if errors.Is(err, os.ErrNotExist) {
+		// a new file, the changes start with an empty line
+		text, err = buffer.NewPieceText(LineType{}), nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if hash != sf.Hash {
+		return nil, nil, errSwapOutdated
+	}
+	if err := sf.check(text.Len()); err != nil {
+		return nil, nil, err
+	}
+	lines := buffer.LineSlice(buffer.AllLines(text))
+	sf.replay(&lines)
+	return text, &lines, nil
+}
+
+// editTextStruct changes the text of a document with undo actions
+type editTextStruct struct {
+	*DocStruct
+	ui *buffer.Edit
+}
+
+func (et editTextStruct) SetLine(y int, line LineType) {
+	et.updateLine(et.ui, y, line)
+}
+
+func (et editTextStruct) InsertLines(y int, lines ...LineType) {
+	for i, line := range lines {
+		et.insertLine(et.ui, y+i, line)
+	}
+}
+
+func (et editTextStruct) DeleteLines(from, to int) {
+	for y := from; y < to; y++ {
+		et.deleteLine(et.ui, from)
+	}
+}
+
+func (et editTextStruct) Each(y int, f func(y int, line LineType) bool) {
+	et.Text().Each(y, f)
+}
+
+// recoverSwap replays the changes of the stale swap file on the saved state
+// of the document, the recovery is one undo step
+func (doc *DocStruct) recoverSwap() error {
+	sf := doc.swap.stale
+	if sf.Hash != doc.savedHash || doc.Undo.Saved < 0 {
+		return errSwapOutdated
+	}
+	if doc.Undo.Current != doc.Undo.Saved {
+		doc.gotoUndoState(doc.Undo.Saved)
+	}
+	if err := sf.check(doc.Len()); err != nil {
+		return err
+	}
+	doc.swap.stale = nil
+	ui := buffer.Edit{}
+	sf.replay(editTextStruct{doc, &ui})
+	doc.Undo.Push(ui)
+	doc.selection = emptySelection
+	doc.clampCursor()
+	return doc.writeSwap()
+}
+
+// handleEventRecover asks what to do with the swap file of a crashed session
+func (doc *DocStruct) handleEventRecover() {
+	sf := doc.swap.stale
+	if sf == nil {
+		doc.setStatus("no swap file to recover")
+		return
+	}
+	doc.openPrompt(&PromptStruct{
+		label: fmt.Sprintf("swap file of %s from %s found: (r)ecover (d)iff (x) delete",
+			doc.filename, sf.Time.Format("2006-01-02 15:04:05")),
+		onKey: func(event *tcell.EventKey) bool {
+			if event.Key() == tcell.KeyEscape || event.Key() == tcell.KeyCtrlC {
+				doc.closePrompt()
+				doc.setStatus("swap file kept, the command recover asks again")
+				return true
+			}
+			if event.Key() != tcell.KeyRune {
+				return true
+			}
+			switch event.Rune() {
+			case 'r':
+				doc.closePrompt()
+				if err := doc.recoverSwap(); err != nil {
+					doc.setError("not recovered: %v", err)
+					return true
+				}
+				doc.adjustViewport()
+				doc.setStatus("recovered %s, save to keep the changes", doc.filename)
+			case 'd':
+				doc.closePrompt()
+				file, recovered, err := doc.swapText(sf)
+				if err != nil {
+					doc.setError("%v", err)
+					return true
+				}
+				editor.showScratch("*swap diff "+doc.filename+"*",
+					unifiedDiff(doc.filename, "swap file", file, recovered))
+				editor.doc().setStatus("F2 returns, the command recover asks again")
+			case 'x':
+				doc.closePrompt()
+				doc.swap.stale = nil
+				if err := os.Remove(doc.swap.name); err != nil {
+					doc.setError("%v", err)
+				} else {
+					doc.setStatus("swap file deleted")
+				}
+			}
+			return true
+		},
+	})
+}
+
+// showScratch shows lines in a read only document called name, a previous
+// document of the same name is replaced
+func (ed *EditorStruct) showScratch(name string, lines []LineType) {
+	var doc *DocStruct
+	for _, d := range ed.docs {
+		if d.filename == name {
+			doc = d
+		}
+	}
+	if doc == nil {
+		doc = newDoc(name)
+		doc.readonly = true
+		doc.scratch = true
+		ed.addDoc(doc)
+	}
+	doc.SetText(buffer.NewPieceText(lines...))
+	doc.absolutCursor = CursorStruct{}
+	doc.viewport = xyStruct{}
+	doc.topRow = 0
+	doc.selection = emptySelection
+	ed.show(doc)
+	ed.renderWindows()
+}
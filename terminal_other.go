@@ -0,0 +1,16 @@
+//go:build !(aix || darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris || zos)
+// +build !aix,!darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!solaris,!zos
+
+package main
+
+import (
+	"io"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+// newScreen creates the screen, escape sequences can't be sent directly
+func newScreen() (tcell.Screen, io.Writer, error) {
+	screen, err := tcell.NewScreen()
+	return screen, nil, err
+}
@@ -0,0 +1,24 @@
+//go:build aix || darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris || zos
+// +build aix darwin dragonfly freebsd linux netbsd openbsd solaris zos
+
+package main
+
+import (
+	"io"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+// newScreen creates the screen on the controlling terminal and returns the
+// terminal as well, to send escape sequences tcell doesn't know about
+func newScreen() (tcell.Screen, io.Writer, error) {
+	tty, err := tcell.NewDevTty()
+	if err != nil {
+		return nil, nil, err
+	}
+	screen, err := tcell.NewTerminfoScreenFromTty(tty)
+	if err != nil {
+		return nil, nil, err
+	}
+	return screen, tty, nil
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"jostermeier.de/edit/buffer"
+)
+
+func TestDecodeText(t *testing.T) {
+	data := []byte("zero\r\none\ntwo\rthree\n\nfive")
+	text, format := utf8Encoding.decodeText(data, nil)
+	if !format.mixed || format.finalNewline || text.Len() != 6 {
+		t.Fatalf("format %v, %d lines", format, text.Len())
+	}
+	var got []string
+	for _, line := range buffer.AllLines(text) {
+		got = append(got, string(line))
+	}
+	if strings.Join(got, ",") != "zero,one,two,three,,five" {
+		t.Fatalf("lines %q", got)
+	}
+	// changed lines don't refer to the data anymore
+	text.SetLine(1, LineType("changed"))
+	data[0] = 'Z'
+	if got := string(text.Line(0)) + "," + string(text.Line(1)); got != "Zero,changed" {
+		t.Errorf("lines %q", got)
+	}
+}
+
+func TestMappedFile(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "large.txt")
+	line := strings.Repeat("x", 99) + "\n"
+	if err := os.WriteFile(name, []byte(strings.Repeat(line, mapThreshold/len(line)+1)), 0644); err != nil {
+		t.Fatalf("Error %v", err)
+	}
+	doc := newTestDoc(t)
+	doc.filename = name
+	if err := doc.handleEventLoad(); err != nil {
+		t.Fatalf("Error %v", err)
+	}
+	if pt, ok := doc.Text().(*buffer.PieceText); ok && !pt.Mapped() {
+		t.Skip("mapping files isn't supported")
+	}
+
+	// saving refers to the new file, also when it was written in place
+	doc.absolutCursor = CursorStruct{x: 0, y: 1}
+	typeString(doc, "changed")
+	if err := doc.handleEventSave(); err != nil {
+		t.Fatalf("Error %v", err)
+	}
+	if got := string(doc.Line(1)); got != "changed"+strings.Repeat("x", 99) {
+		t.Errorf("line after saving is %q", got)
+	}
+
+	// writing the mapped file in place is noticed, replacing it isn't a
+	// problem
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatalf("Error %v", err)
+	}
+	f.WriteString("appended\n")
+	f.Close()
+	if info, err := os.Stat(name); err != nil || !doc.overwrittenInPlace(info) {
+		t.Errorf("writing in place not noticed, %v", err)
+	}
+	replaced := name + ".new"
+	if err := os.WriteFile(replaced, []byte("new\n"), 0644); err != nil {
+		t.Fatalf("Error %v", err)
+	}
+	if info, err := os.Stat(replaced); err != nil || doc.overwrittenInPlace(info) {
+		t.Errorf("another file taken as written in place, %v", err)
+	}
+
+	// lines of a file truncated by another program are empty
+	if err := os.Truncate(name, 0); err != nil {
+		t.Fatalf("Error %v", err)
+	}
+	if got := string(doc.Line(doc.Len() - 1)); got != "" {
+		t.Errorf("line of the truncated file is %q", got)
+	}
+}
+
+// benchmarkLines returns the content of a file with n lines
+func benchmarkLines(n int) []byte {
+	var b strings.Builder
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&b, "line %d of the benchmark with some more text\n", i)
+	}
+	return []byte(b.String())
+}
+
+// sliceText decodes data into a slice like before the piece table
+func sliceText(data []byte) *buffer.LineSlice {
+	byteLines, _ := splitLines(data)
+	lines := make(buffer.LineSlice, 0, len(byteLines))
+	for _, byteLine := range byteLines {
+		lines = append(lines, decodeUTF8Line(byteLine))
+	}
+	return &lines
+}
+
+func pieceText(data []byte) *buffer.PieceText {
+	text, _ := utf8Encoding.decodeText(data, nil)
+	return text
+}
+
+func benchmarkTexts(b *testing.B, run func(b *testing.B, newText func([]byte) buffer.Text)) {
+	b.Run("slice", func(b *testing.B) {
+		run(b, func(data []byte) buffer.Text { return sliceText(data) })
+	})
+	b.Run("piece", func(b *testing.B) {
+		run(b, func(data []byte) buffer.Text { return pieceText(data) })
+	})
+}
+
+func BenchmarkLoad(b *testing.B) {
+	data := benchmarkLines(100000)
+	benchmarkTexts(b, func(b *testing.B, newText func([]byte) buffer.Text) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			newText(data)
+		}
+	})
+}
+
+// BenchmarkLoadDoc opens a file like the editor does, with the swap file
+// and the undo history
+func BenchmarkLoadDoc(b *testing.B) {
+	b.Setenv("XDG_STATE_HOME", b.TempDir())
+	filename := filepath.Join(b.TempDir(), "bench.txt")
+	if err := os.WriteFile(filename, benchmarkLines(100000), 0644); err != nil {
+		b.Fatalf("Error %v", err)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		doc, err := loadDoc(filename, "", false)
+		if err != nil {
+			b.Fatalf("Error %v", err)
+		}
+		doc.removeSwap()
+	}
+}
+
+func BenchmarkInsertDelete(b *testing.B) {
+	data := benchmarkLines(100000)
+	benchmarkTexts(b, func(b *testing.B, newText func([]byte) buffer.Text) {
+		text := newText(data)
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			y := (i * 7919) % text.Len()
+			text.InsertLines(y, LineType("inserted"))
+			text.SetLine(y+1, LineType("changed"))
+			text.DeleteLines(y, y+1)
+		}
+	})
+}
+
+func BenchmarkEach(b *testing.B) {
+	data := benchmarkLines(100000)
+	benchmarkTexts(b, func(b *testing.B, newText func([]byte) buffer.Text) {
+		text := newText(data)
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			n := 0
+			text.Each(0, func(y int, line LineType) bool {
+				n += len(line)
+				return true
+			})
+		}
+	})
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"embed"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+// A theme file sets the styles of the editor, the format is described in
+// the built-in default theme. Colors have alternatives for terminals with
+// less colors, the first one the terminal can show is used.
+
+//go:embed themes/*.theme
+var builtinThemeFiles embed.FS
+
+// themeDir holds the themes of the user, which replace built-in themes of
+// the same name
+var themeDir string
+
+// ThemeStruct holds the styles of the editor, it is shared by all views
+type ThemeStruct struct {
+	name                   string
+	defaultStyle           tcell.Style // text
+	selectionStyle         tcell.Style
+	searchStyle            tcell.Style // search matches
+	infoStyle              tcell.Style // info line and document list
+	statusStyle            tcell.Style // status line and prompt
+	errorStyle             tcell.Style // errors in the status line
+	lineNumberStyle        tcell.Style
+	currentLineNumberStyle tcell.Style
+	separatorStyle         tcell.Style            // line between windows
+	syntaxStyles           map[string]tcell.Style // by token class of the syntax highlighting
+}
+
+// syntaxStyle returns the style of a token class
+func (theme *ThemeStruct) syntaxStyle(class string) tcell.Style {
+	if style, ok := theme.syntaxStyles[class]; ok {
+		return style
+	}
+	return theme.defaultStyle
+}
+
+// colorsNeeded returns the number of colors a terminal needs to show color
+func colorsNeeded(color tcell.Color) int {
+	switch {
+	case color == tcell.ColorReset || color == tcell.ColorDefault:
+		return 0
+	case color.IsRGB():
+		return 1 << 24
+	case color < tcell.ColorValid+8:
+		return 8
+	case color < tcell.ColorValid+16:
+		return 16
+	}
+	return 256
+}
+
+// parseColor returns the first alternative of spec the terminal can show,
+// or the last one which tcell maps to the nearest color
+func parseColor(spec string, colors int) (tcell.Color, error) {
+	var color tcell.Color
+	for _, alternative := range strings.Split(spec, "/") {
+		if n, err := strconv.Atoi(alternative); err == nil && n >= 0 && n < 256 {
+			color = tcell.PaletteColor(n)
+		} else if strings.HasPrefix(alternative, "#") && len(alternative) == 7 {
+			v, err := strconv.ParseInt(alternative[1:], 16, 32)
+			if err != nil {
+				return color, fmt.Errorf("invalid color %q", alternative)
+			}
+			color = tcell.NewHexColor(int32(v))
+		} else if alternative == "default" {
+			color = tcell.ColorReset
+		} else if c, ok := tcell.ColorNames[alternative]; ok {
+			color = c
+		} else {
+			return color, fmt.Errorf("unknown color %q", alternative)
+		}
+		if colorsNeeded(color) <= colors {
+			break
+		}
+	}
+	return color, nil
+}
+
+// parseStyle changes base as described by spec: a foreground color, "on"
+// and a background color, and attributes
+func parseStyle(spec string, base tcell.Style, colors int) (tcell.Style, error) {
+	style := base
+	background, foregroundSet := false, false
+	for _, field := range strings.Fields(spec) {
+		switch field {
+		case "on":
+			background = true
+		case "bold":
+			style = style.Bold(true)
+		case "dim":
+			style = style.Dim(true)
+		case "italic":
+			style = style.Italic(true)
+		case "underline":
+			style = style.Underline(true)
+		case "reverse":
+			style = style.Reverse(true)
+		default:
+			color, err := parseColor(field, colors)
+			if err != nil {
+				return style, err
+			}
+			if background {
+				style = style.Background(color)
+			} else if !foregroundSet {
+				style = style.Foreground(color)
+				foregroundSet = true
+			} else {
+				return style, fmt.Errorf("expected \"on\" before background color %q", field)
+			}
+		}
+	}
+	return style, nil
+}
+
+// parseTheme reads a theme for a terminal showing colors colors. Styles
+// which don't set a color use the color of the text.
+func parseTheme(name string, data []byte, colors int) (*ThemeStruct, error) {
+	specs := map[string]string{}
+	lineNumbers := map[string]int{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, found := cut(line, "=")
+		if !found {
+			return nil, fmt.Errorf("%s:%d: missing '=' in %q", name, lineNumber, line)
+		}
+		key = strings.TrimSpace(key)
+		specs[key] = strings.TrimSpace(value)
+		lineNumbers[key] = lineNumber
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	theme := &ThemeStruct{name: specs["name"], syntaxStyles: map[string]tcell.Style{}}
+	if theme.name == "" {
+		return nil, fmt.Errorf("%s: missing name", name)
+	}
+	parse := func(key string, base tcell.Style) (tcell.Style, error) {
+		style, err := parseStyle(specs[key], base, colors)
+		if err != nil {
+			return style, fmt.Errorf("%s:%d: %v", name, lineNumbers[key], err)
+		}
+		return style, nil
+	}
+	text, err := parse("text", tcell.StyleDefault.Foreground(tcell.ColorReset).Background(tcell.ColorReset))
+	if err != nil {
+		return nil, err
+	}
+	theme.defaultStyle = text
+	styles := map[string]*tcell.Style{
+		"selection":         &theme.selectionStyle,
+		"search":            &theme.searchStyle,
+		"info":              &theme.infoStyle,
+		"status":            &theme.statusStyle,
+		"error":             &theme.errorStyle,
+		"linenumber":        &theme.lineNumberStyle,
+		"currentlinenumber": &theme.currentLineNumberStyle,
+		"separator":         &theme.separatorStyle,
+	}
+	for key := range specs {
+		if key == "name" || key == "text" {
+			continue
+		}
+		style, err := parse(key, text)
+		if err != nil {
+			return nil, err
+		}
+		if s, ok := styles[key]; ok {
+			*s = style
+			delete(styles, key)
+		} else {
+			// other keys are classes of the syntax highlighting
+			theme.syntaxStyles[key] = style
+		}
+	}
+	for _, s := range styles {
+		*s = text
+	}
+	return theme, nil
+}
+
+// readTheme returns the theme file of name, a theme of the user is preferred
+func readTheme(name string) ([]byte, error) {
+	if themeDir != "" {
+		data, err := os.ReadFile(filepath.Join(themeDir, name+".theme"))
+		if err == nil || !os.IsNotExist(err) {
+			return data, err
+		}
+	}
+	data, err := builtinThemeFiles.ReadFile(path.Join("themes", name+".theme"))
+	if err != nil {
+		return nil, fmt.Errorf("unknown theme %q", name)
+	}
+	return data, nil
+}
+
+// themeNames returns the names of the built-in themes and of the user
+func themeNames() []string {
+	unique := map[string]bool{}
+	builtin, _ := builtinThemeFiles.ReadDir("themes")
+	for _, entry := range builtin {
+		unique[strings.TrimSuffix(entry.Name(), ".theme")] = true
+	}
+	if themeDir != "" {
+		files, _ := filepath.Glob(filepath.Join(themeDir, "*.theme"))
+		for _, file := range files {
+			unique[strings.TrimSuffix(filepath.Base(file), ".theme")] = true
+		}
+	}
+	names := make([]string, 0, len(unique))
+	for name := range unique {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// setTheme loads the theme name for the colors of the terminal, all views
+// use it immediately
+func (screen *ScreenStruct) setTheme(name string) error {
+	data, err := readTheme(name)
+	if err != nil {
+		return err
+	}
+	theme, err := parseTheme(name, data, screen.Colors())
+	if err != nil {
+		return err
+	}
+	*screen.ThemeStruct = *theme
+	screen.SetStyle(theme.defaultStyle)
+	return nil
+}
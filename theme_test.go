@@ -0,0 +1,53 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+func TestParseTheme(t *testing.T) {
+	for _, name := range themeNames() {
+		data, err := readTheme(name)
+		if err != nil {
+			t.Fatalf("Error %v", err)
+		}
+		for _, colors := range []int{8, 16, 256, 1 << 24} {
+			if _, err := parseTheme(name, data, colors); err != nil {
+				t.Errorf("Error %v", err)
+			}
+		}
+	}
+
+	data := []byte("name = test\ntext = #abb2bf/249/silver on #282c34/235/black\nselection = reverse\ncomment = #5c6370/241/gray italic\n")
+	for _, test := range []struct {
+		colors int
+		want   tcell.Color
+	}{
+		{1 << 24, tcell.NewHexColor(0x5c6370)},
+		{256, tcell.PaletteColor(241)},
+		{16, tcell.ColorGray},
+		{8, tcell.ColorGray},
+	} {
+		theme, err := parseTheme("test", data, test.colors)
+		if err != nil {
+			t.Fatalf("Error %v", err)
+		}
+		fg, bg, attrs := theme.syntaxStyle("comment").Decompose()
+		if fg != test.want || attrs&tcell.AttrItalic == 0 {
+			t.Errorf("%d colors: comment has color %v, expected %v", test.colors, fg, test.want)
+		}
+		if _, textBg, _ := theme.defaultStyle.Decompose(); bg != textBg {
+			t.Errorf("%d colors: comment has background %v, expected the one of text %v", test.colors, bg, textBg)
+		}
+		if _, _, attrs := theme.selectionStyle.Decompose(); attrs&tcell.AttrReverse == 0 {
+			t.Errorf("%d colors: selection isn't reversed", test.colors)
+		}
+	}
+
+	_, err := parseTheme("test", []byte("name = test\ntext = red blue\n"), 256)
+	if err == nil || !strings.HasPrefix(err.Error(), "test:2:") {
+		t.Errorf("expected error in line 2, got %v", err)
+	}
+}
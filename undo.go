@@ -1,109 +1,76 @@
 package main
 
-import "errors"
+import (
+	"errors"
+	"strconv"
+	"time"
 
-type ActionStruct struct {
-	row     int
-	delete  bool
-	insert  bool
-	update  bool
-	line    LineType
-	cursorX int
-}
+	"jostermeier.de/edit/buffer"
+)
 
-type UndoItemStruct struct {
-	actionSlice []ActionStruct
-}
+// The undo tree is kept by the buffer package, the functions below connect
+// it to the keys and commands.
 
-func newUndoItem() UndoItemStruct {
-	ui := UndoItemStruct{
-		actionSlice: []ActionStruct{},
+// gotoUndoState undoes and redoes changes until the document is in the state of node target
+func (doc *DocStruct) gotoUndoState(target int) {
+	cursor := doc.GotoState(target, buffer.Position{X: doc.absolutCursor.x, Y: doc.absolutCursor.y})
+	doc.absolutCursor.x, doc.absolutCursor.y = cursor.X, cursor.Y
+	if doc.absolutCursor.y >= doc.Len() {
+		doc.absolutCursor.y = doc.Len() - 1
 	}
-	return ui
-}
-
-type UndoStackStruct struct {
-	undoSlice []UndoItemStruct
-	top       int
-}
-
-func (us *UndoStackStruct) push(ui UndoItemStruct) {
-	if !us.merge(ui) {
-		us.undoSlice = append(us.undoSlice, ui)
-		us.top++
+	doc.updateWantX()
+	if doc.selection != emptySelection {
+		// the selection may cover lines which weren't changed
+		doc.selection = emptySelection
+		doc.renderScreen()
 	}
+	doc.adjustViewport()
 }
 
-func (us *UndoStackStruct) merge(ui UndoItemStruct) bool {
-	if len(ui.actionSlice) > 1 {
-		return false // multiple actions... can't merge
-	}
-	action := ui.actionSlice[0]
-	if action.insert || action.delete {
-		return false // insert or delete... can't merge
-	}
-	// get previous undo item
-	if us.top <= 0 {
-		return false // no unto items available
-	}
-	prevUndoItem := us.undoSlice[us.top-1]
-
-	if len(prevUndoItem.actionSlice) > 1 {
-		return false // multiple actions... can't merge
-	}
-	prevAction := prevUndoItem.actionSlice[0]
-	if prevAction.insert || prevAction.delete {
-		return false // insert or delete... can't merge
-	}
-	if action.row != prevAction.row {
-		return false // different rows affected... can't merge
+func (doc *DocStruct) handleEventUndo() {
+	if doc.Undo.Current <= 0 {
+		doc.setStatus("already at oldest change")
+		return
 	}
-	// not required to save a new undo item (single updates on same row)
-	return true
+	doc.gotoUndoState(doc.Undo.Nodes[doc.Undo.Current].Parent)
 }
 
-func (us *UndoStackStruct) pop() (UndoItemStruct, error) {
-	if us.top <= 0 {
-		return UndoItemStruct{}, errors.New("stack empty")
+func (doc *DocStruct) handleEventRedo() {
+	node := doc.Undo.Nodes[doc.Undo.Current]
+	if len(node.Children) == 0 {
+		doc.setStatus("already at newest change")
+		return
 	}
-	us.top--
-	ui := us.undoSlice[us.top]
-	us.undoSlice = us.undoSlice[:us.top]
-	return ui, nil
+	doc.gotoUndoState(node.Children[node.ActiveChild])
 }
 
-func (doc *DocStruct) handleEventUndo() {
-	ui, err := doc.undoStack.pop()
-	if err != nil {
+// handleEventRedoBranch selects the next branch to be followed by redo
+func (doc *DocStruct) handleEventRedoBranch() {
+	node := &doc.Undo.Nodes[doc.Undo.Current]
+	if len(node.Children) < 2 {
+		doc.setStatus("no other branch")
 		return
 	}
+	node.ActiveChild = (node.ActiveChild + 1) % len(node.Children)
+	doc.setStatus("redo follows branch %d of %d", node.ActiveChild+1, len(node.Children))
+}
 
-	for i := len(ui.actionSlice) - 1; i >= 0; i-- {
-		action := ui.actionSlice[i]
-		if action.delete {
-			doc.insertLine(nil, action.row, action.line)
-			doc.absolutCursor.x = action.cursorX
-			doc.absolutCursor.wantX = action.cursorX
-			doc.absolutCursor.y = action.row
-			doc.adjustViewport()
-		}
-		if action.insert {
-			doc.deleteLine(nil, action.row)
-			doc.absolutCursor.x = action.cursorX
-			doc.absolutCursor.wantX = action.cursorX
-			doc.absolutCursor.y = action.row
-			doc.adjustViewport()
-		}
-		if action.update {
-			doc.text[action.row] = action.line
-			doc.absolutCursor.x = action.cursorX
-			doc.absolutCursor.wantX = action.cursorX
-			doc.absolutCursor.y = action.row
-			if !doc.mustAdjustViewport() {
-				doc.renderLine(action.row)
-			} else {
-				doc.adjustViewport()
-			}
+var errUndoArgument = errors.New("expected a count like 3 or a duration like 5m")
+
+// undoTarget interprets the argument of the earlier and later commands
+func undoTarget(ut *buffer.UndoTree, arg string, forward bool) (int, error) {
+	if d, err := time.ParseDuration(arg); err == nil {
+		if !forward {
+			d = -d
 		}
+		return ut.StateAt(ut.Nodes[ut.Current].Time.Add(d)), nil
+	}
+	count, err := strconv.Atoi(arg)
+	if err != nil || count <= 0 {
+		return 0, errUndoArgument
+	}
+	if !forward {
+		count = -count
 	}
+	return ut.StateBySteps(count), nil
 }
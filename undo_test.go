@@ -0,0 +1,228 @@
+package main
+
+import (
+	"crypto/sha256"
+	"errors"
+	"testing"
+
+	"github.com/gdamore/tcell/v2"
+	"jostermeier.de/edit/buffer"
+)
+
+// newTestDoc returns a document with the given lines shown on a simulation screen
+func newTestDoc(t *testing.T, lines ...string) *DocStruct {
+	emptySelection = selectionStruct{
+		begin: xyStruct{x: -1, y: -1},
+		end:   xyStruct{x: -1, y: -1},
+	}
+	screen := tcell.NewSimulationScreen("")
+	if err := screen.Init(); err != nil {
+		t.Fatalf("Error %v", err)
+	}
+	screen.SetSize(80, 25)
+	doc := &DocStruct{
+		BufferStruct: newBuffer(""),
+		screen:       ScreenStruct{Screen: screen, ThemeStruct: &ThemeStruct{}},
+		selection:    emptySelection,
+	}
+	text := []LineType{}
+	for _, line := range lines {
+		text = append(text, LineType(line))
+	}
+	doc.SetText(buffer.NewPieceText(text...))
+	return doc
+}
+
+func docText(doc *DocStruct) string {
+	s := ""
+	for i, line := range buffer.AllLines(doc.Text()) {
+		if i > 0 {
+			s += "\n"
+		}
+		s += string(line)
+	}
+	return s
+}
+
+// typeString handles the keys of s and redraws the changes like the event loop
+func typeString(doc *DocStruct, s string) {
+	for _, r := range s {
+		if r == '\n' {
+			doc.handleEventEnter()
+		} else {
+			doc.handleEventInsertCharacter(r)
+		}
+		doc.renderChanges()
+		doc.redraw = redrawStruct{}
+	}
+}
+
+func TestUndoRedo(t *testing.T) {
+	doc := newTestDoc(t, "")
+	typeString(doc, "ab\ncd")
+	if docText(doc) != "ab\ncd" {
+		t.Fatalf("Error %q", docText(doc))
+	}
+	doc.handleEventUndo()
+	if docText(doc) != "ab\n" {
+		t.Fatalf("Error %q", docText(doc))
+	}
+	doc.handleEventUndo()
+	if docText(doc) != "ab" {
+		t.Fatalf("Error %q", docText(doc))
+	}
+	doc.handleEventRedo()
+	doc.handleEventRedo()
+	if docText(doc) != "ab\ncd" {
+		t.Fatalf("Error %q", docText(doc))
+	}
+	doc.handleEventUndo()
+	doc.handleEventUndo()
+	doc.handleEventUndo()
+	if docText(doc) != "" {
+		t.Fatalf("Error %q", docText(doc))
+	}
+}
+
+func TestUndoTree(t *testing.T) {
+	doc := newTestDoc(t, "")
+	typeString(doc, "a\n")
+	doc.handleEventUndo()
+	typeString(doc, "b")
+	if docText(doc) != "ab" {
+		t.Fatalf("Error %q", docText(doc))
+	}
+	// the undone line break is kept in another branch
+	doc.handleEventUndo()
+	doc.handleEventRedoBranch()
+	doc.handleEventRedo()
+	if docText(doc) != "a\n" {
+		t.Fatalf("Error %q", docText(doc))
+	}
+	// go back in time to the other branch
+	target, err := undoTarget(&doc.Undo, "1", true)
+	if err != nil {
+		t.Fatalf("Error %v", err)
+	}
+	doc.gotoUndoState(target)
+	if docText(doc) != "ab" {
+		t.Fatalf("Error %q", docText(doc))
+	}
+	doc.gotoUndoState(0)
+	if docText(doc) != "" {
+		t.Fatalf("Error %q", docText(doc))
+	}
+}
+
+func TestUndoHistoryFile(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	doc := newTestDoc(t, "")
+	doc.filename = "history.txt"
+	typeString(doc, "a\nb")
+	doc.handleEventUndo()
+	doc.savedHash = sha256.Sum256([]byte("a\n"))
+	if err := doc.saveUndoHistory(); err != nil {
+		t.Fatalf("Error %v", err)
+	}
+
+	// file was changed in between
+	other := newTestDoc(t, "a", "")
+	other.filename = doc.filename
+	if err := other.loadUndoHistory(); !errors.Is(err, errUndoFileOutdated) {
+		t.Fatalf("Error %v", err)
+	}
+
+	other.savedHash = doc.savedHash
+	if err := other.loadUndoHistory(); err != nil {
+		t.Fatalf("Error %v", err)
+	}
+	other.handleEventRedo()
+	if docText(other) != "a\nb" {
+		t.Fatalf("Error %q", docText(other))
+	}
+	other.gotoUndoState(0)
+	if docText(other) != "" {
+		t.Fatalf("Error %q", docText(other))
+	}
+}
+
+func TestInvalidUndoHistory(t *testing.T) {
+	hash := sha256.Sum256([]byte("a\n"))
+	tree := func() buffer.UndoTree {
+		return buffer.UndoTree{Nodes: []buffer.UndoNode{
+			{Parent: -1, Children: []int{1, 2}, ActiveChild: 1},
+			{Parent: 0},
+			{Parent: 0},
+		}}
+	}
+	ut := tree()
+	data, err := encodeUndoTree(&ut, hash)
+	if err != nil {
+		t.Fatalf("Error %v", err)
+	}
+	if _, err := decodeUndoTree(data, hash); err != nil {
+		t.Fatalf("Error %v", err)
+	}
+
+	corrupt := []func(ut *buffer.UndoTree){
+		func(ut *buffer.UndoTree) { ut.Nodes[0].Parent = 1 },
+		func(ut *buffer.UndoTree) { ut.Nodes[1].Parent = -1 },
+		func(ut *buffer.UndoTree) { ut.Nodes[1].Parent = 2 },
+		func(ut *buffer.UndoTree) { ut.Nodes[2].Parent = 1 },
+		func(ut *buffer.UndoTree) { ut.Nodes[0].Children = []int{1, 3} },
+		func(ut *buffer.UndoTree) { ut.Nodes[0].ActiveChild = 2 },
+		func(ut *buffer.UndoTree) { ut.Nodes[1].ActiveChild = -1 },
+		func(ut *buffer.UndoTree) { ut.Current = 3 },
+	}
+	for i, f := range corrupt {
+		ut := tree()
+		f(&ut)
+		data, err := encodeUndoTree(&ut, hash)
+		if err != nil {
+			t.Fatalf("Error %v", err)
+		}
+		if _, err := decodeUndoTree(data, hash); err == nil {
+			t.Errorf("corrupt tree %d was accepted", i)
+		}
+	}
+}
+
+func TestModified(t *testing.T) {
+	doc := newTestDoc(t, "")
+	if doc.modified() {
+		t.Errorf("new document shouldn't be modified")
+	}
+	typeString(doc, "ab")
+	if !doc.modified() {
+		t.Errorf("typing should modify")
+	}
+	doc.handleEventUndo()
+	if doc.modified() {
+		t.Errorf("undo to the loaded state should be unmodified")
+	}
+	doc.handleEventRedo()
+
+	// save point after typing, changes of the same line aren't merged into it
+	doc.Undo.Saved = doc.Undo.Current
+	doc.absolutCursor.x = 2
+	typeString(doc, "c")
+	if !doc.modified() || docText(doc) != "abc" {
+		t.Errorf("typing after save should modify: %q", docText(doc))
+	}
+	doc.handleEventUndo()
+	if doc.modified() || docText(doc) != "ab" {
+		t.Errorf("undo to the saved state should be unmodified: %q", docText(doc))
+	}
+	doc.handleEventUndo()
+	if !doc.modified() {
+		t.Errorf("undo behind the saved state should modify")
+	}
+	doc.handleEventRedo()
+
+	if err := doc.executeCommand("lineending crlf"); err != nil {
+		t.Fatalf("Error %v", err)
+	}
+	if !doc.modified() {
+		t.Errorf("changing the line ending should modify")
+	}
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+
+	"jostermeier.de/edit/buffer"
+)
+
+// The undo history of a file is stored when the file is saved, together with
+// a hash of the saved content. When the file is opened again and still has
+// this content, the history is loaded, so undo works across sessions.
+
+const undoFileVersion = 1
+
+// gob only encodes exported fields, so the history is copied into these structs
+type undoFileStruct struct {
+	Version int
+	Hash    [sha256.Size]byte
+	Current int
+	Nodes   []undoFileNodeStruct
+}
+
+type undoFileNodeStruct struct {
+	Parent      int
+	Children    []int
+	ActiveChild int
+	Undo        []undoFileActionStruct
+	Redo        []undoFileActionStruct
+	Time        time.Time
+}
+
+type undoFileActionStruct struct {
+	Row     int
+	Delete  bool
+	Insert  bool
+	Update  bool
+	Line    []rune
+	CursorX int
+}
+
+// stateDir returns the directory for data which should survive a restart,
+// like $XDG_STATE_HOME on linux
+func stateDir() (string, error) {
+	if dir := os.Getenv("XDG_STATE_HOME"); dir != "" {
+		return filepath.Join(dir, "edit"), nil
+	}
+	if runtime.GOOS == "windows" || runtime.GOOS == "darwin" {
+		dir, err := os.UserConfigDir()
+		if err != nil {
+			return "", err
+		}
+		return filepath.Join(dir, "edit", "state"), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".local", "state", "edit"), nil
+}
+
+// stateFile returns the name of the file in the state directory subdir for
+// the document filename
+func stateFile(subdir, filename string) (string, error) {
+	dir, err := stateDir()
+	if err != nil {
+		return "", err
+	}
+	abs, err := filepath.Abs(filename)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(abs))
+	return filepath.Join(dir, subdir, hex.EncodeToString(sum[:16])), nil
+}
+
+func encodeActions(e buffer.Edit) []undoFileActionStruct {
+	actions := make([]undoFileActionStruct, 0, len(e.Actions))
+	for _, a := range e.Actions {
+		actions = append(actions, undoFileActionStruct{
+			Row:     a.Row,
+			Delete:  a.Delete,
+			Insert:  a.Insert,
+			Update:  a.Update,
+			Line:    a.Line,
+			CursorX: a.CursorX,
+		})
+	}
+	return actions
+}
+
+func decodeActions(actions []undoFileActionStruct) buffer.Edit {
+	e := buffer.Edit{}
+	for _, a := range actions {
+		e.Actions = append(e.Actions, buffer.Action{
+			Row:     a.Row,
+			Delete:  a.Delete,
+			Insert:  a.Insert,
+			Update:  a.Update,
+			Line:    a.Line,
+			CursorX: a.CursorX,
+		})
+	}
+	return e
+}
+
+func encodeUndoTree(ut *buffer.UndoTree, hash [sha256.Size]byte) ([]byte, error) {
+	uf := undoFileStruct{
+		Version: undoFileVersion,
+		Hash:    hash,
+		Current: ut.Current,
+		Nodes:   make([]undoFileNodeStruct, 0, len(ut.Nodes)),
+	}
+	for _, node := range ut.Nodes {
+		uf.Nodes = append(uf.Nodes, undoFileNodeStruct{
+			Parent:      node.Parent,
+			Children:    node.Children,
+			ActiveChild: node.ActiveChild,
+			Undo:        encodeActions(node.Undo),
+			Redo:        encodeActions(node.Redo),
+			Time:        node.Time,
+		})
+	}
+
+	var out bytes.Buffer
+	zw := gzip.NewWriter(&out)
+	if err := gob.NewEncoder(zw).Encode(uf); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+var errUndoFileOutdated = errors.New("undo history doesn't match the file")
+
+func decodeUndoTree(data []byte, hash [sha256.Size]byte) (buffer.UndoTree, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return buffer.UndoTree{}, err
+	}
+	uf := undoFileStruct{}
+	if err := gob.NewDecoder(zr).Decode(&uf); err != nil {
+		return buffer.UndoTree{}, err
+	}
+	if uf.Version != undoFileVersion || uf.Hash != hash {
+		return buffer.UndoTree{}, errUndoFileOutdated
+	}
+	if len(uf.Nodes) == 0 || uf.Current < 0 || uf.Current >= len(uf.Nodes) || !validUndoNodes(uf.Nodes) {
+		return buffer.UndoTree{}, errors.New("invalid undo history")
+	}
+
+	ut := buffer.UndoTree{
+		Nodes:   make([]buffer.UndoNode, 0, len(uf.Nodes)),
+		Current: uf.Current,
+		Saved:   uf.Current, // the history is stored when the file is saved
+	}
+	for _, node := range uf.Nodes {
+		ut.Nodes = append(ut.Nodes, buffer.UndoNode{
+			Parent:      node.Parent,
+			Children:    node.Children,
+			ActiveChild: node.ActiveChild,
+			Undo:        decodeActions(node.Undo),
+			Redo:        decodeActions(node.Redo),
+			Time:        node.Time,
+		})
+	}
+	return ut, nil
+}
+
+// validUndoNodes reports whether the nodes form a tree: the root has no
+// parent, every other node has a parent created before it, children point
+// back to their parent and the active child exists
+func validUndoNodes(nodes []undoFileNodeStruct) bool {
+	for i, node := range nodes {
+		if i == 0 && node.Parent != -1 || i > 0 && (node.Parent < 0 || node.Parent >= i) {
+			return false
+		}
+		for _, child := range node.Children {
+			if child <= i || child >= len(nodes) || nodes[child].Parent != i {
+				return false
+			}
+		}
+		if node.ActiveChild < 0 || node.ActiveChild > 0 && node.ActiveChild >= len(node.Children) {
+			return false
+		}
+	}
+	return true
+}
+
+// saveUndoHistory stores the undo tree for the content which was saved last
+func (doc *DocStruct) saveUndoHistory() error {
+	if !config.getBool("persistent_undo", true) {
+		return nil
+	}
+	name, err := stateFile("undo", doc.filename)
+	if err != nil {
+		return err
+	}
+	data, err := encodeUndoTree(&doc.Undo, doc.savedHash)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(name), 0700); err != nil {
+		return err
+	}
+	return writeFileAtomic(name, data)
+}
+
+// loadUndoHistory restores the undo tree if the file wasn't changed since
+// the history was stored
+func (doc *DocStruct) loadUndoHistory() error {
+	if !config.getBool("persistent_undo", true) {
+		return nil
+	}
+	name, err := stateFile("undo", doc.filename)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return err
+	}
+	ut, err := decodeUndoTree(data, doc.savedHash)
+	if err != nil {
+		return err
+	}
+	doc.Undo = ut
+	return nil
+}
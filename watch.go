@@ -0,0 +1,261 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+	"jostermeier.de/edit/buffer"
+)
+
+// Files changed by other programs are noticed by watching the directories
+// of the open files where the system supports it, and by comparing
+// modification time and size every few seconds. A document without unsaved
+// changes is reloaded, otherwise the user decides. The reload is one undo
+// step, so the text before it is never lost.
+
+// startWatcher starts checking the open files for changes
+func startWatcher(screen tcell.Screen) {
+	var pending int32
+	check := func() {
+		// a burst of notifications results in one check
+		if !atomic.CompareAndSwapInt32(&pending, 0, 1) {
+			return
+		}
+		err := screen.PostEvent(tcell.NewEventInterrupt(func() {
+			atomic.StoreInt32(&pending, 0)
+			editor.checkFiles()
+		}))
+		if err != nil {
+			atomic.StoreInt32(&pending, 0)
+		}
+	}
+	editor.watch, _ = startNotify(check)
+	for _, doc := range editor.docs {
+		editor.watchFile(doc)
+	}
+
+	interval := time.Duration(config.getInt("watch_interval", 2)) * time.Second
+	if interval <= 0 {
+		return
+	}
+	go func() {
+		for range time.Tick(interval) {
+			check()
+		}
+	}()
+}
+
+// watchFile watches the directory of the file of doc
+func (ed *EditorStruct) watchFile(doc *DocStruct) {
+	if ed.watch == nil || doc.scratch {
+		return
+	}
+	if abs, err := filepath.Abs(doc.filename); err == nil {
+		ed.watch(filepath.Dir(abs))
+	}
+}
+
+// changedOnDisk reports whether the file was changed by another program,
+// info is the current state of the file
+func (doc *DocStruct) changedOnDisk() (changed bool, info os.FileInfo, err error) {
+	info, err = os.Stat(doc.filename)
+	if err != nil {
+		return false, nil, err
+	}
+	if doc.diskInfo != nil && info.ModTime().Equal(doc.diskInfo.ModTime()) && info.Size() == doc.diskInfo.Size() {
+		return false, info, nil
+	}
+	// the content may be the same, like after touch
+	_, _, hash, err := doc.readFile()
+	if err != nil {
+		return false, nil, err
+	}
+	if hash == doc.savedHash {
+		doc.diskInfo = info
+		return false, info, nil
+	}
+	return true, info, nil
+}
+
+// overwrittenInPlace reports whether the mapped file of the text was
+// written in place by another program, info is the current state of the
+// file. Then the lines which weren't edited show the new content already.
+func (doc *DocStruct) overwrittenInPlace(info os.FileInfo) bool {
+	pt, ok := doc.Text().(*buffer.PieceText)
+	if !ok {
+		return false
+	}
+	mapping, ok := pt.Mapping().(*mappingStruct)
+	if !ok || !os.SameFile(mapping.info, info) {
+		return false
+	}
+	return !mapping.info.ModTime().Equal(info.ModTime()) || mapping.info.Size() != info.Size()
+}
+
+// checkFiles reloads documents whose file was changed by another program,
+// or asks what to do if the document has unsaved changes
+func (ed *EditorStruct) checkFiles() {
+	for _, doc := range ed.docs {
+		if doc.scratch {
+			continue
+		}
+		changed, info, err := doc.changedOnDisk()
+		if errors.Is(err, os.ErrNotExist) && doc.diskInfo != nil {
+			doc.diskInfo = nil
+			doc.Undo.Saved = -1
+			ed.doc().setError("%s was deleted on disk", doc.filename)
+			continue
+		}
+		if !changed {
+			continue
+		}
+		inPlace := doc.overwrittenInPlace(info)
+		if !doc.modified() {
+			doc.diskInfo = info
+			if err := doc.reload(); err != nil {
+				ed.doc().setError("%v", err)
+				continue
+			}
+			ed.renderWindows()
+			if inPlace {
+				ed.doc().setError("reloaded %s, it was overwritten in place, undo can't restore it", doc.filename)
+			} else {
+				ed.doc().setStatus("reloaded %s, it was changed on disk", doc.filename)
+			}
+			continue
+		}
+		if ed.doc().prompt != nil {
+			continue // ask when the prompt is closed
+		}
+		doc.diskInfo = info
+		ed.show(doc)
+		ed.doc().confirmReload(inPlace)
+		return
+	}
+}
+
+// applyText changes the text to the one of text as one undo step. Only the
+// lines which differ are changed, so the cursor stays on its line.
+func (doc *DocStruct) applyText(text buffer.Text) {
+	ui := buffer.Edit{}
+	cursorY := -1
+	y := doc.absolutCursor.y
+	for _, d := range diffLines(doc.Text(), text) {
+		// the rows of the document are the ones of text up to d.b now
+		switch d.op {
+		case diffEqual:
+			if cursorY < 0 && y >= d.a && y < d.a+d.count {
+				cursorY = d.b + y - d.a
+			}
+		case diffDelete:
+			if cursorY < 0 && y >= d.a && y < d.a+d.count {
+				cursorY = d.b
+			}
+			for i := 0; i < d.count; i++ {
+				doc.deleteLine(&ui, d.b)
+			}
+		case diffInsert:
+			if cursorY < 0 && y == d.a {
+				cursorY = d.b
+			}
+			for i := 0; i < d.count; i++ {
+				doc.insertLine(&ui, d.b+i, text.Line(d.b+i))
+			}
+		}
+	}
+	if doc.Len() == 0 {
+		doc.insertLine(&ui, 0, LineType{})
+	}
+	doc.Undo.Push(ui)
+	if cursorY >= 0 {
+		doc.absolutCursor.y = cursorY
+	}
+	doc.selection = emptySelection
+	doc.clampCursor()
+	doc.alignCursorX()
+}
+
+// reload reads the file again, the changes are one undo step
+func (doc *DocStruct) reload() error {
+	text, format, hash, err := doc.readFile()
+	if err != nil {
+		return err
+	}
+	doc.applyText(text)
+	// the lines are equal now, the new text doesn't refer to the old file
+	doc.SetText(text)
+	doc.format = format
+	doc.savedFormat = format
+	doc.savedHash = hash
+	doc.Undo.Saved = doc.Undo.Current
+	doc.adjustViewport()
+	return nil
+}
+
+// confirmReload asks whether a file with unsaved changes which was changed
+// on disk should be reloaded. inPlace tells that the mapped file was
+// overwritten, the lines which weren't edited show the new content already.
+func (doc *DocStruct) confirmReload(inPlace bool) {
+	label := doc.filename + " changed on disk: (r)eload (k)eep (d)iff"
+	if inPlace {
+		label = doc.filename + " overwritten in place, unedited lines changed: (r)eload (k)eep (d)iff"
+	}
+	doc.openPrompt(&PromptStruct{
+		label: label,
+		onKey: func(event *tcell.EventKey) bool {
+			keep := func() {
+				// the document differs from the file now
+				doc.Undo.Saved = -1
+				doc.setStatus("kept, saving overwrites the file on disk")
+			}
+			if event.Key() == tcell.KeyEscape || event.Key() == tcell.KeyCtrlC {
+				doc.closePrompt()
+				keep()
+				return true
+			}
+			if event.Key() != tcell.KeyRune {
+				return true
+			}
+			switch event.Rune() {
+			case 'r':
+				doc.closePrompt()
+				doc.handleEventReload()
+			case 'k':
+				doc.closePrompt()
+				keep()
+			case 'd':
+				doc.closePrompt()
+				keep()
+				text, _, _, err := doc.readFile()
+				if err != nil {
+					doc.setError("%v", err)
+					return true
+				}
+				editor.showScratch("*disk diff "+doc.filename+"*",
+					unifiedDiff(doc.filename, "on disk", doc.Text(), text))
+				editor.doc().setStatus("F2 returns, the command reload loads the file on disk")
+			}
+			return true
+		},
+	})
+}
+
+// handleEventReload reloads the file, undo restores the text before
+func (doc *DocStruct) handleEventReload() {
+	modified := doc.modified()
+	if err := doc.reload(); err != nil {
+		doc.setError("%v", err)
+		return
+	}
+	doc.diskInfo, _ = os.Stat(doc.filename)
+	editor.renderWindows()
+	if modified {
+		doc.setStatus("reloaded %s, undo restores your changes", doc.filename)
+	} else {
+		doc.setStatus("reloaded %s", doc.filename)
+	}
+}
@@ -0,0 +1,44 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// startNotify calls notify when something in a watched directory changes,
+// directories are added with the returned function on the event loop
+func startNotify(notify func()) (func(dir string), error) {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC)
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		buffer := make([]byte, 64*(unix.SizeofInotifyEvent+unix.NAME_MAX+1))
+		for {
+			n, err := unix.Read(fd, buffer)
+			if err == unix.EINTR {
+				continue
+			}
+			if err != nil {
+				return
+			}
+			if n > 0 {
+				notify()
+			}
+		}
+	}()
+
+	watched := map[string]bool{}
+	return func(dir string) {
+		if watched[dir] {
+			return
+		}
+		// renames cover editors and tools which replace the file
+		mask := uint32(unix.IN_CLOSE_WRITE | unix.IN_MOVED_TO | unix.IN_MOVED_FROM | unix.IN_DELETE)
+		if _, err := unix.InotifyAddWatch(fd, dir, mask); err == nil {
+			watched[dir] = true
+		}
+	}, nil
+}
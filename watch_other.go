@@ -0,0 +1,11 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import "errors"
+
+// startNotify isn't supported, changes are noticed by polling
+func startNotify(notify func()) (func(dir string), error) {
+	return nil, errors.New("file notification not supported")
+}
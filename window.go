@@ -0,0 +1,264 @@
+package main
+
+import (
+	"github.com/gdamore/tcell/v2"
+)
+
+const (
+	minWindowWidth  = 20
+	minWindowHeight = 4 // info line, status line and two lines of text
+)
+
+// RegionStruct is the part of the screen which belongs to a window. Drawing
+// is relative to its top left corner and clipped at its border, so a
+// document renders the same way in a window as on the whole screen.
+type RegionStruct struct {
+	tcell.Screen
+	x, y, width, height int
+}
+
+func (r *RegionStruct) Size() (int, int) {
+	return r.width, r.height
+}
+
+func (r *RegionStruct) SetContent(x, y int, mainc rune, combc []rune, style tcell.Style) {
+	if x < 0 || y < 0 || x >= r.width || y >= r.height {
+		return
+	}
+	r.Screen.SetContent(r.x+x, r.y+y, mainc, combc, style)
+}
+
+func (r *RegionStruct) GetContent(x, y int) (rune, []rune, tcell.Style, int) {
+	return r.Screen.GetContent(r.x+x, r.y+y)
+}
+
+func (r *RegionStruct) ShowCursor(x, y int) {
+	r.Screen.ShowCursor(r.x+x, r.y+y)
+}
+
+func (r *RegionStruct) Fill(c rune, style tcell.Style) {
+	for y := 0; y < r.height; y++ {
+		for x := 0; x < r.width; x++ {
+			r.Screen.SetContent(r.x+x, r.y+y, c, nil, style)
+		}
+	}
+}
+
+func (r *RegionStruct) Clear() {
+	r.Fill(' ', tcell.StyleDefault)
+}
+
+func (r *RegionStruct) contains(x, y int) bool {
+	return x >= r.x && x < r.x+r.width && y >= r.y && y < r.y+r.height
+}
+
+// WindowStruct is a node of the window layout. A leaf shows a document, the
+// other nodes are split into two windows.
+type WindowStruct struct {
+	doc      *DocStruct // nil if split
+	region   *RegionStruct
+	parent   *WindowStruct
+	children []*WindowStruct // top and bottom, or left and right window
+	vertical bool            // children side by side, separated by a line
+}
+
+// initWindows creates a single window covering the whole screen
+func (ed *EditorStruct) initWindows() {
+	ed.root = &WindowStruct{region: &RegionStruct{Screen: ed.screen.Screen}}
+	ed.focus = ed.root
+	ed.layout()
+}
+
+// leaves returns the windows showing a document, from top left to bottom right
+func (w *WindowStruct) leaves() []*WindowStruct {
+	if w.doc != nil || len(w.children) == 0 {
+		return []*WindowStruct{w}
+	}
+	return append(w.children[0].leaves(), w.children[1].leaves()...)
+}
+
+func (ed *EditorStruct) layout() {
+	width, height := ed.screen.Size()
+	ed.root.layout(0, 0, width, height)
+}
+
+// layout splits the area between the children, the second window gets the
+// remainder
+func (w *WindowStruct) layout(x, y, width, height int) {
+	*w.region = RegionStruct{Screen: w.region.Screen, x: x, y: y, width: width, height: height}
+	if len(w.children) == 0 {
+		return
+	}
+	if w.vertical {
+		first := (width - 1) / 2
+		w.children[0].layout(x, y, first, height)
+		w.children[1].layout(x+first+1, y, width-first-1, height)
+	} else {
+		first := height / 2
+		w.children[0].layout(x, y, width, first)
+		w.children[1].layout(x, y+first, width, height-first)
+	}
+}
+
+// newView returns another view of the buffer of doc at the same position
+func (doc *DocStruct) newView() *DocStruct {
+	view := *doc
+	view.prompt = nil
+	view.lastPaste = nil
+	view.pasteBuffer = nil
+	view.statusMessage = ""
+	return &view
+}
+
+// showIn shows doc in window w and returns the view used. A document which
+// is already shown in another window gets its own view.
+func (ed *EditorStruct) showIn(w *WindowStruct, doc *DocStruct) *DocStruct {
+	for _, other := range ed.root.leaves() {
+		if other != w && other.doc == doc {
+			doc = doc.newView()
+			break
+		}
+	}
+	doc.screen.Screen = w.region
+	w.doc = doc
+	return doc
+}
+
+// renderWindows renders all windows and the lines between them
+func (ed *EditorStruct) renderWindows() {
+	ed.screen.Clear()
+	for _, w := range ed.root.leaves() {
+		w.doc.renderScreen()
+	}
+	ed.root.renderSeparators(ed.screen)
+}
+
+func (w *WindowStruct) renderSeparators(screen ScreenStruct) {
+	if len(w.children) == 0 {
+		return
+	}
+	if w.vertical {
+		x := w.children[1].region.x - 1
+		for y := w.region.y; y < w.region.y+w.region.height; y++ {
+			screen.SetContent(x, y, tcell.RuneVLine, nil, screen.separatorStyle)
+		}
+	}
+	w.children[0].renderSeparators(screen)
+	w.children[1].renderSeparators(screen)
+}
+
+// renderChanges redraws the windows whose buffer was changed by the last
+// event, so changes appear in all windows showing the buffer
+func (ed *EditorStruct) renderChanges() {
+	leaves := ed.root.leaves()
+	for _, w := range leaves {
+		if w != ed.focus && w.doc.redraw != (redrawStruct{}) {
+			w.doc.clampCursor()
+		}
+		w.doc.renderChanges()
+	}
+	for _, doc := range ed.docs {
+		doc.redraw = redrawStruct{}
+	}
+	for _, w := range leaves {
+		w.doc.redraw = redrawStruct{}
+	}
+}
+
+// clampCursor keeps cursor and selection inside the text, which may have
+// been changed in another view
+func (doc *DocStruct) clampCursor() {
+	cursor := doc.clampPosition(xyStruct{x: doc.absolutCursor.x, y: doc.absolutCursor.y})
+	doc.absolutCursor.x, doc.absolutCursor.y = cursor.x, cursor.y
+	if doc.selection != emptySelection && doc.clampPosition(doc.selection.end) != doc.selection.end {
+		doc.selection = emptySelection
+	}
+}
+
+// setFocus moves the focus to window w
+func (ed *EditorStruct) setFocus(w *WindowStruct) {
+	ed.focus = w
+	if i := ed.index(w.doc); i >= 0 && i != ed.current {
+		ed.previous = ed.current
+		ed.current = i
+	}
+}
+
+// handleEventSplitWindow shows the current document in a new window below
+// or right of the current one
+func (ed *EditorStruct) handleEventSplitWindow(vertical bool) {
+	w := ed.focus
+	if (vertical && w.region.width < 2*minWindowWidth+1) || (!vertical && w.region.height < 2*minWindowHeight) {
+		w.doc.setError("window too small to split")
+		return
+	}
+	first := &WindowStruct{doc: w.doc, region: w.region, parent: w}
+	second := &WindowStruct{region: &RegionStruct{Screen: ed.screen.Screen}, parent: w}
+	w.doc = nil
+	w.region = &RegionStruct{Screen: ed.screen.Screen}
+	w.children = []*WindowStruct{first, second}
+	w.vertical = vertical
+	ed.showIn(second, first.doc)
+	ed.setFocus(second)
+	ed.layout()
+	for _, leaf := range ed.root.leaves() {
+		leaf.doc.adjustViewport()
+	}
+	ed.renderWindows()
+}
+
+// handleEventCloseWindow removes the current window, the neighbouring window
+// takes its space
+func (ed *EditorStruct) handleEventCloseWindow() {
+	w := ed.focus
+	if w.parent == nil {
+		w.doc.setStatus("only one window")
+		return
+	}
+	parent := w.parent
+	sibling := parent.children[0]
+	if sibling == w {
+		sibling = parent.children[1]
+	}
+	sibling.parent = parent.parent
+	if parent.parent == nil {
+		ed.root = sibling
+	} else if parent.parent.children[0] == parent {
+		parent.parent.children[0] = sibling
+	} else {
+		parent.parent.children[1] = sibling
+	}
+	ed.setFocus(sibling.leaves()[0])
+	ed.layout()
+	for _, leaf := range ed.root.leaves() {
+		leaf.doc.adjustViewport()
+	}
+	ed.renderWindows()
+}
+
+// handleEventFocusWindow moves the focus to the window next to the current
+// one in direction dx, dy, at the row or column of the cursor
+func (ed *EditorStruct) handleEventFocusWindow(dx, dy int) {
+	r := ed.focus.region
+	doc := ed.focus.doc
+	x := r.x + doc.gutterWidth() + doc.cursorColumn() - doc.viewport.x
+	y := r.y + doc.cursorRow()
+	switch {
+	case dx > 0:
+		x = r.x + r.width + 1 // behind the separator
+	case dx < 0:
+		x = r.x - 2
+	case dy > 0:
+		y = r.y + r.height
+	case dy < 0:
+		y = r.y - 1
+	}
+	for _, w := range ed.root.leaves() {
+		if w.region.contains(x, y) {
+			ed.setFocus(w)
+			w.doc.clampCursor()
+			w.doc.adjustViewport()
+			return
+		}
+	}
+}
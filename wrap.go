@@ -0,0 +1,336 @@
+package main
+
+import "strings"
+
+// In wrap mode a line longer than the window continues in the rows below
+// instead of scrolling horizontally. The rows after the first are indented
+// like the line and start with an indicator. The viewport starts at row
+// topRow of line viewport.y, up and down move the cursor by rows.
+
+type WrapType int
+
+const (
+	WrapOff   WrapType = iota
+	WrapChars          // at the window width
+	WrapWords          // at the last blank which fits
+)
+
+const wrapIndicator = '↪'
+
+func (wrap WrapType) String() string {
+	switch wrap {
+	case WrapChars:
+		return "on"
+	case WrapWords:
+		return "words"
+	default:
+		return "off"
+	}
+}
+
+func parseWrap(s string) (WrapType, bool) {
+	switch strings.ToLower(s) {
+	case "off":
+		return WrapOff, true
+	case "on", "chars":
+		return WrapChars, true
+	case "words":
+		return WrapWords, true
+	}
+	return WrapOff, false
+}
+
+// wrapWidth returns the number of columns of a row, the last column of the
+// window stays free for the cursor behind a full row
+func (doc *DocStruct) wrapWidth() int {
+	maxx, _ := doc.screen.Size()
+	width := maxx - doc.gutterWidth() - 1
+	if width < 1 {
+		width = 1
+	}
+	return width
+}
+
+// wrapIndent returns the columns in front of the text in the rows after
+// the first: the indent of line and the indicator. The indent is left out
+// if it takes more than half of the row.
+func (doc *DocStruct) wrapIndent(line LineType, width int) int {
+	indent := 0
+	for x := 0; x < len(line) && (line[x] == ' ' || line[x] == '\t'); x++ {
+		indent += doc.clusterWidth(line[x:x+1], indent)
+	}
+	indent++ // indicator
+	if indent > width/2 {
+		if width < 2 {
+			return 0
+		}
+		return 1
+	}
+	return indent
+}
+
+// wrapLine returns the index of the first rune of every row of line and
+// the indent of the rows after the first
+func (doc *DocStruct) wrapLine(line LineType) (starts []int, indent int) {
+	width := doc.wrapWidth()
+	indent = doc.wrapIndent(line, width)
+	starts = []int{0}
+	bounds := clusterBounds(line)
+	col := 0    // column in the line, tabs depend on it
+	rowCol := 0 // column in the line where the row starts
+	available := width
+	breakX, breakCol := -1, 0 // behind the last blank of the row
+	for i := 0; i+1 < len(bounds); i++ {
+		x := bounds[i]
+		w := doc.clusterWidth(line[x:bounds[i+1]], col)
+		blank := line[x] == ' ' || line[x] == '\t'
+		if doc.wrap == WrapWords && blank && col-rowCol == available {
+			// a blank behind the word may take the free last column
+			col += w
+			breakX, breakCol = bounds[i+1], col
+			continue
+		}
+		if col+w-rowCol > available && col > rowCol {
+			available = width - indent
+			if doc.wrap == WrapWords && breakX > starts[len(starts)-1] && col+w-breakCol <= available {
+				// the word moves to the next row
+				starts = append(starts, breakX)
+				rowCol = breakCol
+			} else {
+				starts = append(starts, x)
+				rowCol = col
+			}
+			breakX = -1
+		}
+		col += w
+		if blank {
+			breakX, breakCol = bounds[i+1], col
+		}
+	}
+	return starts, indent
+}
+
+// wrapRows returns the number of rows of line y
+func (doc *DocStruct) wrapRows(y int) int {
+	starts, _ := doc.wrapLine(doc.Line(y))
+	return len(starts)
+}
+
+// wrapRow returns the row of the rune at x
+func wrapRow(starts []int, x int) int {
+	row := 0
+	for row+1 < len(starts) && starts[row+1] <= x {
+		row++
+	}
+	return row
+}
+
+// wrapPosition returns the row of the rune at x of line and its column on
+// the screen right of the gutter
+func (doc *DocStruct) wrapPosition(line LineType, x int) (row, col int) {
+	starts, indent := doc.wrapLine(line)
+	row = wrapRow(starts, x)
+	col = doc.column(line, x) - doc.column(line, starts[row])
+	if row > 0 {
+		col += indent
+	}
+	return row, col
+}
+
+// wrapIndex returns the index of the rune of line shown in row at column
+// col, a column behind the row gives its last rune
+func (doc *DocStruct) wrapIndex(line LineType, row, col int) int {
+	starts, indent := doc.wrapLine(line)
+	if row >= len(starts) {
+		row = len(starts) - 1
+	}
+	end := len(line)
+	if row+1 < len(starts) {
+		// the cursor behind the last rune would be in the next row
+		end = prevCluster(line, starts[row+1])
+	}
+	c := 0
+	if row > 0 {
+		c = indent
+	}
+	lineCol := doc.column(line, starts[row])
+	bounds := clusterBounds(line)
+	for i := 0; i+1 < len(bounds) && bounds[i] < end; i++ {
+		if bounds[i] < starts[row] {
+			continue
+		}
+		w := doc.clusterWidth(line[bounds[i]:bounds[i+1]], lineCol)
+		if c+w > col {
+			return bounds[i]
+		}
+		c += w
+		lineCol += w
+	}
+	return end
+}
+
+// nextRow returns the row below row of line y, ok is false at the end of
+// the document
+func (doc *DocStruct) nextRow(y, row int) (int, int, bool) {
+	if row+1 < doc.wrapRows(y) {
+		return y, row + 1, true
+	}
+	if y+1 < doc.Len() {
+		return y + 1, 0, true
+	}
+	return y, row, false
+}
+
+// prevRow returns the row above row of line y, ok is false at the start of
+// the document
+func (doc *DocStruct) prevRow(y, row int) (int, int, bool) {
+	if row > 0 {
+		return y, row - 1, true
+	}
+	if y > 0 {
+		return y - 1, doc.wrapRows(y-1) - 1, true
+	}
+	return y, row, false
+}
+
+// moveRows moves the cursor n rows down, up if n is negative, to the
+// column of wantX
+func (doc *DocStruct) moveRows(n int) {
+	y := doc.absolutCursor.y
+	row, _ := doc.wrapPosition(doc.Line(y), doc.absolutCursor.x)
+	ok := true
+	for ; n > 0 && ok; n-- {
+		y, row, ok = doc.nextRow(y, row)
+	}
+	for ; n < 0 && ok; n++ {
+		y, row, ok = doc.prevRow(y, row)
+	}
+	doc.absolutCursor.y = y
+	doc.absolutCursor.x = doc.wrapIndex(doc.Line(y), row, doc.absolutCursor.wantX)
+}
+
+// cursorRow returns the row of the window the cursor is shown in
+func (doc *DocStruct) cursorRow() int {
+	if doc.wrap == WrapOff {
+		return doc.absolutCursor.y - doc.viewport.y
+	}
+	row := -doc.topRow
+	for y := doc.viewport.y; y < doc.absolutCursor.y; y++ {
+		row += doc.wrapRows(y)
+	}
+	cursorRow, _ := doc.wrapPosition(doc.Line(doc.absolutCursor.y), doc.absolutCursor.x)
+	return row + cursorRow
+}
+
+// adjustWrappedViewport scrolls by rows until the cursor is visible
+func (doc *DocStruct) adjustWrappedViewport() {
+	_, maxy := doc.screen.Size()
+	changed := doc.viewport.x != 0
+	doc.viewport.x = 0
+	if doc.viewport.y < doc.Len() {
+		if rows := doc.wrapRows(doc.viewport.y); doc.topRow >= rows {
+			// the line became shorter or the window wider
+			doc.topRow = rows - 1
+			changed = true
+		}
+	}
+	y := doc.absolutCursor.y
+	row, _ := doc.wrapPosition(doc.Line(y), doc.absolutCursor.x)
+	if y < doc.viewport.y || (y == doc.viewport.y && row < doc.topRow) {
+		doc.viewport.y, doc.topRow = y, row
+		changed = true
+	} else if y-doc.viewport.y >= maxy-1 || doc.cursorRow() >= maxy-1 {
+		// the cursor becomes the last row, above the status line
+		topY, topRow := y, row
+		for n := 0; n < maxy-2; n++ {
+			var ok bool
+			if topY, topRow, ok = doc.prevRow(topY, topRow); !ok {
+				break
+			}
+		}
+		doc.viewport.y, doc.topRow = topY, topRow
+		changed = true
+	}
+	if changed {
+		doc.renderScreen()
+	}
+}
+
+// renderWrappedLines draws all rows of the window, a change in a line can
+// move the lines below it
+func (doc *DocStruct) renderWrappedLines() {
+	maxx, maxy := doc.screen.Size()
+	row := 0
+	first := doc.topRow
+	for y := doc.viewport.y; y < doc.Len() && row < maxy-1; y++ {
+		row = doc.renderWrappedLine(y, row, first)
+		first = 0
+	}
+	for ; row < maxy-1; row++ {
+		for x := 0; x < maxx; x++ {
+			doc.screen.SetContent(x, row, ' ', nil, doc.screen.defaultStyle)
+		}
+	}
+}
+
+// renderWrappedLine draws line y from its row first on in the rows of the
+// window from row on, it returns the row below the line
+func (doc *DocStruct) renderWrappedLine(y, row, first int) int {
+	maxx, maxy := doc.screen.Size()
+	gutter := doc.gutterWidth()
+	line := doc.Line(y)
+	starts, indent := doc.wrapLine(line)
+	matches := doc.searchMatches(line)
+	classes := doc.lineClasses(y)
+	bounds := clusterBounds(line)
+	i, col := 0, 0
+	for r := range starts {
+		end := len(line)
+		if r+1 < len(starts) {
+			end = starts[r+1]
+		}
+		if r < first {
+			for ; bounds[i] < end; i++ {
+				col += doc.clusterWidth(line[bounds[i]:bounds[i+1]], col)
+			}
+			continue
+		}
+		if row >= maxy-1 {
+			break
+		}
+		if r == 0 {
+			doc.renderLineNumber(row, y)
+		} else {
+			doc.renderString(0, row, strings.Repeat(" ", gutter), doc.screen.lineNumberStyle)
+		}
+		x := gutter
+		if r > 0 && indent > 0 {
+			for ; x < gutter+indent-1; x++ {
+				doc.screen.SetContent(x, row, ' ', nil, doc.screen.defaultStyle)
+			}
+			doc.screen.SetContent(x, row, wrapIndicator, nil, doc.screen.lineNumberStyle)
+			x++
+		}
+		for ; bounds[i] < end; i++ {
+			cluster := line[bounds[i]:bounds[i+1]]
+			w := doc.clusterWidth(cluster, col)
+			ch, comb, cells := clusterCell(cluster, w)
+			style := doc.runeStyle(xyStruct{x: bounds[i], y: y}, matches, classes)
+			for c := 0; c < cells && x+c < maxx; c++ {
+				doc.screen.SetContent(x+c, row, ch, comb, style)
+			}
+			x += w
+			col += w
+		}
+		// mark the first character if an empty line is part of selection
+		if len(line) == 0 && (xyStruct{x: 0, y: y}).in(doc.selection) {
+			doc.screen.SetContent(x, row, ' ', nil, doc.screen.selectionStyle)
+			x++
+		}
+		for ; x < maxx; x++ {
+			doc.screen.SetContent(x, row, ' ', nil, doc.screen.defaultStyle)
+		}
+		row++
+	}
+	return row
+}
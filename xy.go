@@ -1,5 +1,7 @@
 package main
 
+import "jostermeier.de/edit/buffer"
+
 type xyStruct struct {
 	x, y int
 }
@@ -53,3 +55,12 @@ func (xy xyStruct) in(sel selectionStruct) bool {
 		return false
 	}
 }
+
+// position converts xy to a position in the buffer
+func (xy xyStruct) position() buffer.Position {
+	return buffer.Position{X: xy.x, Y: xy.y}
+}
+
+func xyOf(p buffer.Position) xyStruct {
+	return xyStruct{x: p.X, y: p.Y}
+}